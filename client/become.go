@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Become methods
+const (
+	BecomeNone = "none"
+	BecomeSudo = "sudo"
+	BecomeDoas = "doas"
+)
+
+// Become is the privilege escalation for the commands reading logs
+type Become struct {
+	method   string
+	user     string
+	password []byte
+}
+
+// NewBecome returns Become. The default method is sudo.
+func NewBecome(method, user string, password []byte) (*Become, error) {
+	switch method {
+	case "":
+		method = BecomeSudo
+	case BecomeSudo, BecomeDoas:
+	case BecomeNone:
+		if user != "" {
+			return nil, fmt.Errorf("becomeUser can not be used with become: %s", method)
+		}
+	default:
+		return nil, fmt.Errorf("unsupport become: %s", method)
+	}
+	if len(password) > 0 && method != BecomeSudo {
+		return nil, fmt.Errorf("password can not be used with become: %s", method)
+	}
+	return &Become{
+		method:   method,
+		user:     user,
+		password: password,
+	}, nil
+}
+
+// wrap returns the command run as the become user
+func (b *Become) wrap(cmd string) string {
+	if b.method == BecomeNone {
+		return cmd
+	}
+	args := []string{b.method}
+	if b.user != "" {
+		args = append(args, "-u", shellQuote(b.user))
+	}
+	if b.method == BecomeSudo && len(b.password) > 0 {
+		// read the password from stdin without prompt
+		args = append(args, "-S", "-p", "''")
+	}
+	args = append(args, "sh", "-c", shellQuote(pipefailShell), "sh", shellQuote(cmd))
+	return strings.Join(args, " ")
+}
+
+// stdin returns the reader passing the password to sudo, or nil
+func (b *Become) stdin() io.Reader {
+	if b.method != BecomeSudo || len(b.password) == 0 {
+		return nil
+	}
+	p := make([]byte, 0, len(b.password)+1)
+	p = append(p, b.password...)
+	p = append(p, '\n')
+	return bytes.NewReader(p)
+}
+
+func shellQuote(s string) string {
+	return fmt.Sprintf("'%s'", strings.Replace(s, "'", `'\''`, -1))
+}
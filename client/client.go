@@ -2,32 +2,82 @@ package client
 
 import (
 	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
 	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 const (
 	initialScanTokenSize = 4096
 	maxScanTokenSize     = 1024 * 1024
+	maxStderrStash       = 10
 )
 
+// decompressCommand reads the file names from stdin and decompresses each file according to the magic bytes ( xz, bzip2, zstd, or `zcat -f` for gzip and plain text ).
+// gzcat is used instead of zcat if exists, because zcat of macOS does not read gzip files.
+const decompressCommand = `{ z=zcat; command -v gzcat >/dev/null 2>&1 && z=gzcat; while IFS= read -r f; do case "$(head -c 6 "$f" | od -An -tx1 | tr -d ' \n')" in fd377a585a00*) xz -dc "$f" ;; 425a683[1-9]*) bzip2 -dc "$f" ;; 28b52ffd*) zstd -dcq "$f" ;; *) "$z" -f "$f" ;; esac || exit; done; }`
+
+// pipefail makes the exit status of a pipeline reflect the failure of any command in it ( if the shell supports it )
+const pipefail = "(set -o pipefail) 2>/dev/null && set -o pipefail; "
+
+// pipefailShell is the sh script running the command ( $1 ) with bash if exists, because some sh ( e.g. dash ) does not support pipefail
+const pipefailShell = `if command -v bash >/dev/null 2>&1; then exec bash -c "$1"; fi; exec sh -c "$1"`
+
 // Client ...
 type Client interface {
 	Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error
 	Tailf(ctx context.Context) error
 	RandomOne(ctx context.Context) error
 	Ls(ctx context.Context, st *time.Time, et *time.Time) error
-	Copy(ctx context.Context, filePath string, dstDir string) error
+	Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error
 	Out() <-chan Line
 }
 
+// execClient is the Client that can execute commands ( SSHClient, FileClient )
+type execClient interface {
+	Client
+	Exec(ctx context.Context, cmd string) error
+}
+
+// execRunner runs commands with execClient and converts the output lines
+type execRunner struct {
+	exec     execClient
+	lineChan chan Line
+	logger   *zap.Logger
+}
+
+// run executes cmd and converts the output lines to r.lineChan using bind
+func (r *execRunner) run(ctx context.Context, cmd string, bind func(in <-chan Line)) error {
+	done := make(chan struct{})
+	go func() {
+		defer func() {
+			r.logger.Debug("Close chan client.Line")
+			close(r.lineChan)
+			close(done)
+		}()
+		bind(r.exec.Out())
+	}()
+	err := r.exec.Exec(ctx, cmd)
+	<-done
+	return err
+}
+
 // Line ...
 type Line struct {
 	Host               string
@@ -35,50 +85,93 @@ type Line struct {
 	Content            string
 	TimeZone           string
 	TimestampViaClient *time.Time
+	Fields             map[string]string
+}
+
+// ExecError is returned when the command for reading logs exits with non-zero status
+type ExecError struct {
+	Host       string
+	Path       string
+	ExitStatus int
+	Stderr     string
+}
+
+func (e *ExecError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("command exited with status %d (%s:%s)", e.ExitStatus, e.Host, e.Path)
+	}
+	return fmt.Sprintf("command exited with status %d (%s:%s): %s", e.ExitStatus, e.Host, e.Path, e.Stderr)
 }
 
 var syslogTimestampAMRe = regexp.MustCompile(`^([a-zA-Z]{3}) ([0-9] .+)$`)
 
 // buildReadCommand ...
-func buildReadCommand(path string, st, et *time.Time, timeFormat, timeZone string) string {
+func buildReadCommand(path string, st *time.Time, tf *timeFilter) string {
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
 
-	stRunes := []rune(st.Format(fmt.Sprintf("%s %s", timeFormat, timeZone)))
-	etRunes := []rune(et.Format(fmt.Sprintf("%s %s", timeFormat, timeZone)))
-
-	matches := []rune{}
-	for idx, r := range stRunes {
-		if r != etRunes[idx] {
-			break
-		}
-		matches = append(matches, r)
-	}
+	findStart := st.Format("2006-01-02 15:04:05 MST")
 
-	grepStr := string(matches)
-	// for syslog timestamp
-	if syslogTimestampAMRe.MatchString(grepStr) {
-		grepStr = syslogTimestampAMRe.ReplaceAllString(string(matches), "$1  $2")
-	}
+	cmd := fmt.Sprintf("find %s/ -type f -name '%s' -newermt '%s' | xargs ls -tr | %s", dir, base, findStart, decompressCommand)
 
-	findStart := st.Format("2006-01-02 15:04:05 MST")
+	return pipefail + cmd + tf.command()
+}
 
-	cmd := fmt.Sprintf("sudo find %s/ -type f -name '%s' -newermt '%s' | xargs sudo ls -tr | xargs sudo zcat -f | grep -a '%s'", dir, base, findStart, grepStr)
-	if timeFormat == "unixtime" {
-		cmd = fmt.Sprintf("sudo find %s/ -type f -name '%s' -newermt '%s' | xargs sudo ls -tr | xargs sudo zcat -f", dir, base, findStart)
+// buildCompressCommand returns the command compressing the output of cmd with gzip or zstd
+func buildCompressCommand(cmd, method string) string {
+	compressor := "gzip -c -1"
+	if method == "zstd" {
+		compressor = "zstd -q -c -1"
 	}
-
-	return cmd
+	return fmt.Sprintf("%s{ %s; } | %s", pipefail, cmd, compressor)
 }
 
-// buildTailfCommand ...
+// buildTailfCommand returns the command following all files matching the path ( including files created later ).
+// Each output line is prefixed with the file path and a tab, and a line of only a tab is printed every second
+// so that the command exits ( and kills the tail processes ) when the output is closed.
+// Files are tracked by inode, so the rotated ( renamed ) files are not read again, and compressed files are ignored.
+// The tail of a file is stopped when the inode of its path changes ( rotated or deleted ),
+// and inodes no longer found are forgotten, so a new file reusing the inode is followed.
 func buildTailfCommand(path string) string {
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
 
-	cmd := fmt.Sprintf("sudo find %s/ -type f -name '%s' | xargs sudo ls -tr | tail -1 | xargs sudo tail -F", dir, base)
+	script := strings.Join([]string{
+		"set -f",
+		"IFS='\n'",
+		"pids=''",
+		`trap 'IFS=" "; kill $pids 2>/dev/null' EXIT`,
+		"trap 'exit 0' HUP INT TERM PIPE",
+		"inodes=' '",
+		"n=0",
+		// tail exits by itself when the command is killed ( GNU tail only )
+		"p=''",
+		`tail --pid=$$ -n 0 /dev/null >/dev/null 2>&1 && p="--pid=$$"`,
+		// inode returns the inode of the path ( or "" )
+		`inode() { j=$(ls -di "$1" 2>/dev/null); j=${j#"${j%%[! ]*}"}; echo "${j%% *}"; }`,
+		"while :; do",
+		"found=' '",
+		fmt.Sprintf("for e in $(find %s/ -type f -name '%s' -exec ls -di {} + 2>/dev/null); do", dir, base),
+		`e=${e#"${e%%[! ]*}"}; i=${e%% *}; f=${e#* }`,
+		`found="$found$i "`,
+		`case "$inodes" in *" $i "*) continue ;; esac`,
+		`inodes="$inodes$i "`,
+		`case "$f" in *.gz|*.xz|*.bz2|*.zst) continue ;; esac`,
+		`case "$(head -c 6 "$f" | od -An -tx1 | tr -d ' \n')" in 1f8b*|fd377a585a00*|425a683[1-9]*|28b52ffd*) continue ;; esac`,
+		// files found after starting are read from the beginning,
+		// and the tail is killed when the command exits or the inode of the path changes
+		`{ tail -n "$n" -f $p "$f" & t=$!; while kill -0 $$ 2>/dev/null && [ "$(inode "$f")" = "$i" ]; do sleep 1; done; kill $t 2>/dev/null; } | while IFS= read -r l; do printf '%s\t%s\n' "$f" "$l"; done &`,
+		`pids="$pids $!"`,
+		"done",
+		// every followed inode is found, so only inodes of deleted ( or moved away ) files are forgotten
+		`inodes=$found`,
+		"n=+1",
+		`printf '\t\n' || exit 0`,
+		"sleep 1",
+		"done",
+	}, "\n")
 
-	return cmd
+	return fmt.Sprintf("sh -c %s", shellQuote(script))
 }
 
 // buildLsCommand ...
@@ -88,9 +181,9 @@ func buildLsCommand(path string, st *time.Time) string {
 
 	stStr := st.Format("2006-01-02 15:04:05 MST")
 
-	cmd := fmt.Sprintf("sudo find %s/ -type f -name '%s' -newermt '%s' | xargs sudo ls -tr", dir, base, stStr)
+	cmd := fmt.Sprintf("find %s/ -type f -name '%s' -newermt '%s' | xargs ls -tr", dir, base, stStr)
 
-	return cmd
+	return pipefail + cmd
 }
 
 // buildRandomOneCommand ...
@@ -100,12 +193,57 @@ func buildRandomOneCommand(path string) string {
 	rand.Seed(time.Now().UnixNano())
 
 	// why tail -2 -> for 0 line log
-	cmd := fmt.Sprintf("sudo find %s/ -type f -name '%s' | xargs sudo ls -tr | tail -2 | xargs sudo zcat -f | head -%d | tail -1", dir, base, rand.Intn(100)) // #nosec
+	cmd := fmt.Sprintf("find %s/ -type f -name '%s' | xargs ls -tr | tail -2 | %s | head -%d | tail -1", dir, base, decompressCommand, rand.Intn(100)) // #nosec
 
 	return cmd
 }
 
-func bindReaderAndChan(ctx context.Context, l *zap.Logger, r *io.Reader, lineChan chan Line, host string, path string, tz string) {
+// readRandomLine writes a random line ( up to the 100th line ) of the last files to w. open(i) opens the i-th of the count files ( in order of modification time ).
+func readRandomLine(w io.Writer, count int, open func(i int) (io.ReadCloser, error)) error {
+	// why last 2 files -> for 0 line log
+	first := 0
+	if count > 2 {
+		first = count - 2
+	}
+	rand.Seed(time.Now().UnixNano())
+	n := rand.Intn(100) // #nosec
+
+	var (
+		line string
+		read int
+	)
+	for i := first; i < count && read < n; i++ {
+		err := func() error {
+			f, err := open(i)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r, err := newDecompressReader(f)
+			if err != nil {
+				return err
+			}
+			scanner := bufio.NewScanner(r)
+			buf := make([]byte, initialScanTokenSize)
+			scanner.Buffer(buf, maxScanTokenSize)
+			for read < n && scanner.Scan() {
+				line = scanner.Text()
+				read++
+			}
+			return scanner.Err()
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	if read == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+func bindReaderAndChan(ctx context.Context, l *zap.Logger, r *io.Reader, lineChan chan Line, host string, path string, tz string) error {
 	defer func() {
 		l.Debug("Close chan client.Line")
 		close(lineChan)
@@ -127,7 +265,213 @@ L:
 			}
 		}
 	}
-	if scanner.Err() != nil {
+	if scanner.Err() != nil && ctx.Err() == nil {
 		l.Error("Fetch error", zap.Error(scanner.Err()))
+		return scanner.Err()
+	}
+	return nil
+}
+
+// bindTailfLinesAndChan sends the lines of buildTailfCommand to lineChan with the path of the file that each line is read from
+func bindTailfLinesAndChan(in <-chan Line, lineChan chan Line) {
+	defer close(lineChan)
+	for line := range in {
+		if strings.HasPrefix(line.Content, "\t") {
+			// heartbeat
+			continue
+		}
+		s := strings.SplitN(line.Content, "\t", 2)
+		if len(s) == 2 {
+			line.Path = s[0]
+			line.Content = s[1]
+		}
+		lineChan <- line
+	}
+}
+
+// bindWriterFuncAndChan pipes the output written by fn to lineChan
+func bindWriterFuncAndChan(ctx context.Context, l *zap.Logger, lineChan chan Line, host string, path string, tz string, fn func(w io.Writer) error) error {
+	pr, pw := io.Pipe()
+	errChan := make(chan error, 1)
+	go func() {
+		err := fn(pw)
+		_ = pw.CloseWithError(err)
+		errChan <- err
+	}()
+
+	r := io.Reader(pr)
+	bindErr := bindReaderAndChan(ctx, l, &r, lineChan, host, path, tz)
+	_ = pr.Close()
+
+	err := <-errChan
+	if bindErr != nil {
+		return bindErr
+	}
+	if err == io.ErrClosedPipe || ctx.Err() != nil {
+		// canceled
+		return nil
+	}
+	return err
+}
+
+// newDecompressReader returns a reader that decompresses gzip / xz / bzip2 / zstd data transparently (like `zcat -f`)
+func newDecompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch compression(magic) {
+	case "gzip":
+		return gzip.NewReader(br)
+	case "xz":
+		return xz.NewReader(br)
+	case "bzip2":
+		return bzip2.NewReader(br), nil
+	case "zstd":
+		return zstd.NewReader(br, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	}
+	return br, nil
+}
+
+// compression returns the compression format detected by the magic bytes, or "" if not compressed
+func compression(magic []byte) string {
+	switch {
+	case strings.HasPrefix(string(magic), "\x1f\x8b"):
+		return "gzip"
+	case strings.HasPrefix(string(magic), "\xfd7zXZ\x00"):
+		return "xz"
+	case len(magic) >= 4 && strings.HasPrefix(string(magic), "BZh") && magic[3] >= '1' && magic[3] <= '9':
+		return "bzip2"
+	case strings.HasPrefix(string(magic), "\x28\xb5\x2f\xfd"):
+		return "zstd"
+	}
+	return ""
+}
+
+// stderrStash logs stderr of the command and stashes the last lines of it
+type stderrStash struct {
+	logger *zap.Logger
+	lines  []string
+}
+
+func newStderrStash(l *zap.Logger) *stderrStash {
+	return &stderrStash{
+		logger: l,
+		lines:  []string{},
+	}
+}
+
+func (s *stderrStash) bind(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, initialScanTokenSize)
+	scanner.Buffer(buf, maxScanTokenSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.logger.Warn("Command stderr", zap.String("stderr", line))
+		s.lines = append(s.lines, line)
+		if len(s.lines) > maxStderrStash {
+			s.lines = s.lines[1:]
+		}
+	}
+}
+
+func (s *stderrStash) String() string {
+	return strings.Join(s.lines, "\n")
+}
+
+// countingReader counts the bytes read from r to n atomically
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// eofReader closes eof when the underlying reader reaches io.EOF
+type eofReader struct {
+	r    io.Reader
+	eof  chan struct{}
+	once sync.Once
+}
+
+func newEOFReader(r io.Reader) *eofReader {
+	return &eofReader{
+		r:   r,
+		eof: make(chan struct{}),
+	}
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.once.Do(func() {
+			close(r.eof)
+		})
+	}
+	return n, err
+}
+
+// reachedEOF ...
+func (r *eofReader) reachedEOF() bool {
+	select {
+	case <-r.eof:
+		return true
+	default:
+		return false
+	}
+}
+
+// transientMessages are the messages of the errors that may be resolved by retrying
+var transientMessages = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"no route to host",
+	"i/o timeout",
+	"handshake failed",
+	"unexpected EOF",
+	"TLS handshake timeout",
+	"too many requests",
+}
+
+// permanentMessages are the errors that are not resolved by retrying, even if the SSH handshake failed
+var permanentMessages = []string{
+	"unable to authenticate",
+	"knownhosts:",
+	"host key of",
+	"no such file or directory",
+}
+
+// IsTransient reports whether err is a temporary network or API error that may be resolved by retrying
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	err = errors.Cause(err)
+	if err == context.DeadlineExceeded || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if ne, ok := err.(net.Error); ok && (ne.Timeout() || ne.Temporary()) {
+		return true
+	}
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range permanentMessages {
+		if strings.Contains(msg, m) {
+			return false
+		}
+	}
+	for _, m := range transientMessages {
+		if strings.Contains(msg, strings.ToLower(m)) {
+			return true
+		}
 	}
+	return false
 }
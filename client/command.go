@@ -0,0 +1,170 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CommandTime is the time passed to command templates. The default format is RFC3339.
+type CommandTime struct {
+	time.Time
+}
+
+func (t CommandTime) String() string {
+	return t.Format(time.RFC3339)
+}
+
+// commandParams is the parameters of command templates
+type commandParams struct {
+	StartTime CommandTime
+	EndTime   CommandTime
+}
+
+// CommandClient reads the output of the arbitrary command as logs
+type CommandClient struct {
+	execRunner
+	path          string
+	command       *template.Template
+	followCommand string
+}
+
+// NewCommandClient returns CommandClient executing commands via ec ( SSHClient or FileClient ).
+// command is the template for Read ( {{.StartTime}} and {{.EndTime}} are available ), followCommand is for Tailf.
+func NewCommandClient(l *zap.Logger, path, command, followCommand string, ec Client) (Client, error) {
+	if command == "" {
+		return nil, fmt.Errorf("command is not set: %s", path)
+	}
+	tmpl, err := template.New(path).Option("missingkey=error").Parse(command)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := ec.(execClient)
+	if !ok {
+		return nil, fmt.Errorf("client can not execute commands: %T", ec)
+	}
+	c := &CommandClient{
+		execRunner: execRunner{
+			exec:     e,
+			lineChan: make(chan Line),
+			logger:   l,
+		},
+		path:          path,
+		command:       tmpl,
+		followCommand: followCommand,
+	}
+	// validate the template
+	now := time.Now()
+	if _, err := c.buildCommand(&now, &now); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Read ...
+func (c *CommandClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	cmd, err := c.buildCommand(st, et)
+	if err != nil {
+		return err
+	}
+	return c.run(ctx, cmd, c.bindLines)
+}
+
+// Tailf ...
+func (c *CommandClient) Tailf(ctx context.Context) error {
+	if c.followCommand == "" {
+		return fmt.Errorf("followCommand is not set: %s", c.path)
+	}
+	return c.run(ctx, c.followCommand, c.bindLines)
+}
+
+// Ls ...
+func (c *CommandClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	cmd, err := c.buildCommand(st, et)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	c.lineChan <- Line{
+		Host:     c.host(),
+		Path:     c.path,
+		Content:  cmd,
+		TimeZone: "",
+	}
+	return nil
+}
+
+// Copy ...
+func (c *CommandClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	return fmt.Errorf("not supported: copy exec sources: %s", filePath)
+}
+
+// RandomOne runs the command for the last hour and reads the first line
+func (c *CommandClient) RandomOne(ctx context.Context) error {
+	et := time.Now()
+	st := et.Add(-1 * time.Hour)
+	cmd, err := c.buildCommand(&st, &et)
+	if err != nil {
+		return err
+	}
+	innerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	read := false
+	err = c.run(innerCtx, cmd, func(in <-chan Line) {
+		for line := range in {
+			if read {
+				continue
+			}
+			c.lineChan <- line
+			read = true
+			cancel()
+		}
+	})
+	if read {
+		// the command is canceled after reading the first line
+		return nil
+	}
+	return err
+}
+
+// Out ...
+func (c *CommandClient) Out() <-chan Line {
+	return c.lineChan
+}
+
+func (c *CommandClient) buildCommand(st, et *time.Time) (string, error) {
+	params := commandParams{}
+	if st != nil {
+		params.StartTime = CommandTime{*st}
+	}
+	if et != nil {
+		params.EndTime = CommandTime{*et}
+	}
+	buf := new(bytes.Buffer)
+	if err := c.command.Execute(buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *CommandClient) bindLines(in <-chan Line) {
+	for line := range in {
+		c.lineChan <- line
+	}
+}
+
+func (c *CommandClient) host() string {
+	switch e := c.exec.(type) {
+	case *SSHClient:
+		return e.host
+	default:
+		return "localhost"
+	}
+}
@@ -0,0 +1,568 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	dockerSocket       = "/var/run/docker.sock"
+	dockerPollInterval = 1 * time.Second
+)
+
+// DockerClient reads container logs via Docker Engine API
+type DockerClient struct {
+	host             string
+	containerPattern string
+	http             *http.Client
+	jumpHosts        []string
+	pool             *SSHPool
+	maxSessions      int
+	dialCtx          context.Context
+	sshAuth          SSHAuth
+	lineChan         chan Line
+	logger           *zap.Logger
+}
+
+// DockerOption ...
+type DockerOption func(*DockerClient) error
+
+// DockerJumpHosts connect to the remote Docker host through jump hosts
+func DockerJumpHosts(hosts []string) DockerOption {
+	return func(c *DockerClient) error {
+		for _, h := range hosts {
+			if _, err := parseSSHDest(h); err != nil {
+				return err
+			}
+		}
+		c.jumpHosts = hosts
+		return nil
+	}
+}
+
+// DockerSSHPool share the SSH connection to the remote Docker host using the pool
+func DockerSSHPool(p *SSHPool) DockerOption {
+	return func(c *DockerClient) error {
+		c.pool = p
+		return nil
+	}
+}
+
+// DockerMaxSessions set the session limit of the SSH connection shared with other targets ( see MaxSessions )
+func DockerMaxSessions(n int) DockerOption {
+	return func(c *DockerClient) error {
+		c.maxSessions = n
+		return nil
+	}
+}
+
+// DockerDialContext give up connecting to the remote Docker host when ctx is done ( see DialContext )
+func DockerDialContext(ctx context.Context) DockerOption {
+	return func(c *DockerClient) error {
+		c.dialCtx = ctx
+		return nil
+	}
+}
+
+// DockerSSHAuth set the settings of SSH authentication and host key verification to the remote Docker host
+func DockerSSHAuth(a SSHAuth) DockerOption {
+	return func(c *DockerClient) error {
+		if err := a.validate(); err != nil {
+			return err
+		}
+		c.sshAuth = a
+		return nil
+	}
+}
+
+// dockerContainer ...
+type dockerContainer struct {
+	ID      string   `json:"Id"`
+	Names   []string `json:"Names"`
+	State   string   `json:"State"`
+	Created int64    `json:"Created"`
+}
+
+func (dc *dockerContainer) name() string {
+	if len(dc.Names) == 0 {
+		return dc.ID
+	}
+	return strings.TrimPrefix(dc.Names[0], "/")
+}
+
+// NewDockerClient returns DockerClient.
+// If host is localhost, connect to the local Docker daemon ( DOCKER_HOST or /var/run/docker.sock ).
+// Otherwise, connect to /var/run/docker.sock of the host via SSH.
+func NewDockerClient(l *zap.Logger, host, user string, port int, path string, passphrase []byte, opts ...DockerOption) (Client, error) {
+	name := strings.TrimPrefix(path, "/")
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid docker source path: %s", path)
+	}
+	if _, err := matchContainer(name, ""); err != nil {
+		return nil, fmt.Errorf("invalid docker source path: %s", path)
+	}
+	c := &DockerClient{
+		host:             host,
+		containerPattern: name,
+		maxSessions:      DefaultSSHMaxSessions,
+		dialCtx:          context.Background(),
+		lineChan:         make(chan Line),
+		logger:           l,
+	}
+	for _, opt := range opts {
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dial func(ctx context.Context) (net.Conn, error)
+	if host == "localhost" && user == "" && port == 0 {
+		network, addr, err := localDockerAddr()
+		if err != nil {
+			return nil, err
+		}
+		dial = func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	} else {
+		dest := sshDest{host: host, user: user, port: port}
+		var conn *sshConn
+		if c.pool != nil {
+			sc, err := c.pool.get(c.dialCtx, dest, c.jumpHosts, passphrase, c.sshAuth, c.maxSessions)
+			if err != nil {
+				return nil, err
+			}
+			conn = sc
+		} else {
+			client, err := dialSSH(c.dialCtx, dest, c.jumpHosts, passphrase, c.sshAuth)
+			if err != nil {
+				return nil, err
+			}
+			conn = newSSHConn(client, 0)
+		}
+		dial = func(ctx context.Context) (net.Conn, error) {
+			return dialVia(ctx, conn.client.Client, "unix", dockerSocket)
+		}
+	}
+	c.http = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dial(ctx)
+			},
+		},
+	}
+
+	return c, nil
+}
+
+// Read ...
+func (c *DockerClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	containers, err := c.containers(ctx, true)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	if st != nil {
+		q.Set("since", dockerTimestamp(st))
+	}
+	if et != nil {
+		q.Set("until", dockerTimestamp(et))
+	}
+	for _, dc := range containers {
+		err := c.stream(ctx, dc, q)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tailf ...
+func (c *DockerClient) Tailf(ctx context.Context) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+
+	innerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		errs   = make(chan error, 1)
+		since  = map[string]time.Time{}
+		active = map[string]struct{}{}
+		start  = time.Now()
+	)
+	// stop all streams before closing c.lineChan
+	stop := func(err error) error {
+		cancel()
+		wg.Wait()
+		return err
+	}
+	ticker := time.NewTicker(dockerPollInterval)
+	defer ticker.Stop()
+	for {
+		containers, err := c.containers(innerCtx, false)
+		if err != nil {
+			if ctx.Err() != nil {
+				return stop(nil)
+			}
+			return stop(err)
+		}
+		for _, dc := range containers {
+			mu.Lock()
+			if _, ok := active[dc.ID]; ok {
+				mu.Unlock()
+				continue
+			}
+			active[dc.ID] = struct{}{}
+			s, ok := since[dc.ID]
+			if !ok {
+				s = start
+				if time.Unix(dc.Created, 0).After(start) {
+					// started after following, so read from the beginning
+					s = time.Unix(0, 0)
+				}
+			}
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(dc dockerContainer, s time.Time) {
+				defer wg.Done()
+				q := url.Values{}
+				q.Set("follow", "1")
+				q.Set("since", dockerTimestamp(&s))
+				err := c.stream(innerCtx, dc, q)
+				if err != nil && innerCtx.Err() == nil {
+					select {
+					case errs <- errors.Wrap(err, fmt.Sprintf("failed to follow /%s", dc.name())):
+					default:
+					}
+				}
+				mu.Lock()
+				delete(active, dc.ID)
+				since[dc.ID] = time.Now()
+				mu.Unlock()
+			}(dc, s)
+		}
+		select {
+		case <-ctx.Done():
+			return stop(nil)
+		case err := <-errs:
+			return stop(err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ls ...
+func (c *DockerClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	containers, err := c.containers(ctx, true)
+	if err != nil {
+		return err
+	}
+	for _, dc := range containers {
+		p := fmt.Sprintf("/%s", dc.name())
+		c.lineChan <- Line{
+			Host:     c.host,
+			Path:     p,
+			Content:  p,
+			TimeZone: "",
+		}
+	}
+	return nil
+}
+
+// Copy writes logs of the container between st and et to <dstDir>/<host>/<container>.log
+func (c *DockerClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	containers, err := c.containers(ctx, true)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(filePath, "/")
+	for _, dc := range containers {
+		if dc.name() != name {
+			continue
+		}
+		dstLogFilePath := filepath.Join(dstDir, c.host, fmt.Sprintf("%s.log", name))
+		err := os.MkdirAll(filepath.Dir(dstLogFilePath), 0755) // #nosec
+		if err != nil {
+			return err
+		}
+		dst, err := os.Create(dstLogFilePath)
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+		q := url.Values{}
+		if st != nil {
+			q.Set("since", dockerTimestamp(st))
+		}
+		if et != nil {
+			q.Set("until", dockerTimestamp(et))
+		}
+		r, err := c.logs(ctx, dc, q)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(dst, r)
+		return err
+	}
+	return fmt.Errorf("no such container: %s", name)
+}
+
+// RandomOne ...
+func (c *DockerClient) RandomOne(ctx context.Context) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	containers, err := c.containers(ctx, true)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	q.Set("tail", "1")
+	for _, dc := range containers {
+		err := c.stream(ctx, dc, q)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Out ...
+func (c *DockerClient) Out() <-chan Line {
+	return c.lineChan
+}
+
+// containers returns containers matching the filter, in order of creation
+func (c *DockerClient) containers(ctx context.Context, all bool) ([]dockerContainer, error) {
+	q := url.Values{}
+	if all {
+		q.Set("all", "1")
+	}
+	res, err := c.get(ctx, "/containers/json", q)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	list := []dockerContainer{}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, errors.Wrap(err, "failed to decode container list")
+	}
+	containers := []dockerContainer{}
+	for _, dc := range list {
+		if ok, _ := matchContainer(c.containerPattern, dc.name()); ok {
+			containers = append(containers, dc)
+		}
+	}
+	sort.SliceStable(containers, func(i, j int) bool {
+		return containers[i].Created < containers[j].Created
+	})
+	return containers, nil
+}
+
+// logs returns the reader of demultiplexed logs of the container
+func (c *DockerClient) logs(ctx context.Context, dc dockerContainer, q url.Values) (io.ReadCloser, error) {
+	res, err := c.get(ctx, fmt.Sprintf("/containers/%s/json", dc.ID), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	inspect := struct {
+		Config struct {
+			Tty bool `json:"Tty"`
+		} `json:"Config"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&inspect)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode container")
+	}
+
+	q.Set("stdout", "1")
+	q.Set("stderr", "1")
+	res, err = c.get(ctx, fmt.Sprintf("/containers/%s/logs", dc.ID), q)
+	if err != nil {
+		return nil, err
+	}
+	if inspect.Config.Tty {
+		return res.Body, nil
+	}
+	return &dockerDemuxReader{r: res.Body}, nil
+}
+
+// stream sends logs of the container with timestamps to c.lineChan
+func (c *DockerClient) stream(ctx context.Context, dc dockerContainer, q url.Values) error {
+	q.Set("timestamps", "1")
+	name := dc.name()
+	c.logger.Debug(fmt.Sprintf("Open stream: /%s", name))
+	r, err := c.logs(ctx, dc, q)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, initialScanTokenSize)
+	scanner.Buffer(buf, maxScanTokenSize)
+	var prev *time.Time
+	for scanner.Scan() {
+		// containers with TTY output CRLF
+		splitted := strings.SplitN(strings.TrimSuffix(scanner.Text(), "\r"), " ", 2)
+		content := ""
+		if len(splitted) > 1 {
+			content = splitted[1]
+		}
+		ts, err := time.Parse(time.RFC3339Nano, splitted[0])
+		if err != nil {
+			if prev == nil {
+				c.logger.Warn("Skip the line without timestamp", zap.String("host", c.host), zap.String("path", fmt.Sprintf("/%s", name)), zap.Error(err))
+				continue
+			}
+			// use the timestamp of the previous line
+			ts = *prev
+			content = strings.TrimSuffix(scanner.Text(), "\r")
+		}
+		prev = &ts
+		select {
+		case <-ctx.Done():
+			return nil
+		case c.lineChan <- Line{
+			Host:               c.host,
+			Path:               fmt.Sprintf("/%s", name),
+			Content:            content,
+			TimeZone:           "",
+			TimestampViaClient: &ts,
+		}:
+		}
+	}
+	c.logger.Debug(fmt.Sprintf("Close stream: /%s", name))
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+func (c *DockerClient) get(ctx context.Context, p string, q url.Values) (*http.Response, error) {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     "docker",
+		Path:     p,
+		RawQuery: q.Encode(),
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		msg := struct {
+			Message string `json:"message"`
+		}{}
+		if err := json.Unmarshal(b, &msg); err != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(b))
+		}
+		return nil, fmt.Errorf("docker API error (%s:%s): %s", c.host, p, msg.Message)
+	}
+	return res, nil
+}
+
+// matchContainer reports whether the container name matches the glob pattern of the source path
+func matchContainer(pattern, name string) (bool, error) {
+	return path.Match(pattern, name)
+}
+
+// dockerTimestamp returns the timestamp format for since/until of Docker Engine API
+func dockerTimestamp(t *time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// localDockerAddr returns the address of the local Docker daemon
+func localDockerAddr() (string, string, error) {
+	h := os.Getenv("DOCKER_HOST")
+	if h == "" {
+		return "unix", dockerSocket, nil
+	}
+	u, err := url.Parse(h)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case "unix":
+		return "unix", u.Path, nil
+	case "tcp":
+		return "tcp", u.Host, nil
+	default:
+		return "", "", fmt.Errorf("unsupport DOCKER_HOST: %s", h)
+	}
+}
+
+// dockerDemuxReader reads the multiplexed stdout/stderr stream of Docker Engine API
+type dockerDemuxReader struct {
+	r      io.ReadCloser
+	remain uint32
+}
+
+func (d *dockerDemuxReader) Read(p []byte) (int, error) {
+	for d.remain == 0 {
+		header := make([]byte, 8)
+		_, err := io.ReadFull(d.r, header)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		d.remain = binary.BigEndian.Uint32(header[4:])
+	}
+	if uint32(len(p)) > d.remain {
+		p = p[:d.remain]
+	}
+	n, err := d.r.Read(p)
+	d.remain -= uint32(n)
+	if err == io.EOF && d.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (d *dockerDemuxReader) Close() error {
+	return d.r.Close()
+}
@@ -1,13 +1,13 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
@@ -17,33 +17,115 @@ import (
 // FileClient ...
 type FileClient struct {
 	path     string
+	become   *Become
+	filter   *Filter
+	seek     TimestampFunc
+	resume   *resumer
+	head     string
 	lineChan chan Line
 	logger   *zap.Logger
 }
 
+// FileOption ...
+type FileOption func(*FileClient) error
+
+// FileBecomeAs run commands with the privilege escalation
+func FileBecomeAs(b *Become) FileOption {
+	return func(c *FileClient) error {
+		c.become = b
+		return nil
+	}
+}
+
+// FileReadFilter filter lines by the command on reading logs ( only for single-line logs )
+func FileReadFilter(f *Filter) FileOption {
+	return func(c *FileClient) error {
+		c.filter = f
+		return nil
+	}
+}
+
+// FileSeek read only the byte ranges of large uncompressed files between the start time and the end time ( see Seek )
+func FileSeek(ts TimestampFunc) FileOption {
+	return func(c *FileClient) error {
+		c.seek = ts
+		return nil
+	}
+}
+
+// FileResume resume reading uncompressed files from the offsets of the last read, and record the offsets to resume from next time ( see Resume )
+func FileResume(offsets []FileOffset, ts TimestampFunc) FileOption {
+	return func(c *FileClient) error {
+		c.resume = newResumer(offsets, ts)
+		return nil
+	}
+}
+
+// FileRecordHead set the regexp of the first lines of multi-line records ( see RecordHead )
+func FileRecordHead(re string) FileOption {
+	return func(c *FileClient) error {
+		c.head = re
+		return nil
+	}
+}
+
 // NewFileClient ...
-func NewFileClient(l *zap.Logger, path string) (Client, error) {
-	return &FileClient{
+func NewFileClient(l *zap.Logger, path string, opts ...FileOption) (Client, error) {
+	c := &FileClient{
 		path:     path,
+		become:   &Become{method: BecomeSudo},
 		lineChan: make(chan Line),
 		logger:   l,
-	}, nil
+	}
+	for _, opt := range opts {
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 // Read ...
 func (c *FileClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
-	cmd := buildReadCommand(c.path, st, et, timeFormat, timeZone)
-	if runtime.GOOS == "darwin" {
-		cmd = strings.Replace(cmd, "zcat", "gzcat", -1)
+	tz := timeZone
+	if tz == "" {
+		tz = localTimeZone()
 	}
+	tf := newTimeFilter(st, et, timeFormat, tz, c.head)
+	cmd := buildReadCommand(c.path, st, tf)
+	if c.seek != nil || c.resume != nil {
+		var err error
+		cmd, err = buildSeekReadCommand(ctx, c.output, c.path, st, et, tz, tf, c.seek, c.resume)
+		if err != nil {
+			return err
+		}
+	}
+	cmd = cmd + c.filter.buildGrepCommand()
 
 	return c.Exec(ctx, cmd)
 }
 
+// Offsets returns the offsets of the files to resume reading from next time ( ok is false without FileResume )
+func (c *FileClient) Offsets() ([]FileOffset, bool) {
+	if c.resume == nil {
+		return nil, false
+	}
+	return c.resume.next, true
+}
+
 // Tailf ...
 func (c *FileClient) Tailf(ctx context.Context) error {
 	cmd := buildTailfCommand(c.path)
-	return c.Exec(ctx, cmd)
+	in := make(chan Line)
+	done := make(chan struct{})
+	go func() {
+		bindTailfLinesAndChan(in, c.lineChan)
+		close(done)
+	}()
+	err := c.exec(ctx, cmd, in)
+	<-done
+	return err
 }
 
 // Ls ...
@@ -53,33 +135,36 @@ func (c *FileClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error
 }
 
 // Copy ...
-func (c *FileClient) Copy(ctx context.Context, filePath string, dstDir string) error {
+func (c *FileClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
 	dstLogFilePath := filepath.Join(dstDir, filePath)
 	dstLogDir := filepath.Dir(dstLogFilePath)
 	err := os.MkdirAll(dstLogDir, 0755) // #nosec
 	if err != nil {
 		return err
 	}
-	catCmd := fmt.Sprintf("sudo cat %s > %s", filePath, dstLogFilePath)
-	cmd := exec.CommandContext(ctx, "sh", "-c", catCmd) // #nosec
-	err = cmd.Run()
+	dst, err := os.Create(dstLogFilePath)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer dst.Close()
+	cmd := c.command(ctx, fmt.Sprintf("cat %s", shellQuote(filePath)))
+	cmd.Stdout = dst
+	return c.run(ctx, cmd)
 }
 
 // RandomOne ...
 func (c *FileClient) RandomOne(ctx context.Context) error {
 	cmd := buildRandomOneCommand(c.path)
-	if runtime.GOOS == "darwin" {
-		cmd = strings.Replace(cmd, "zcat", "gzcat", -1)
-	}
 	return c.Exec(ctx, cmd)
 }
 
 // Exec ...
 func (c *FileClient) Exec(ctx context.Context, cmdStr string) error {
+	return c.exec(ctx, cmdStr, c.lineChan)
+}
+
+// exec executes cmdStr and sends the output lines to lineChan
+func (c *FileClient) exec(ctx context.Context, cmdStr string, lineChan chan Line) error {
 	c.logger.Info("Create new local exec session")
 	tzCmd := exec.Command("date", `+%z`) // #nosec
 	tzOut, err := tzCmd.Output()
@@ -89,34 +174,105 @@ func (c *FileClient) Exec(ctx context.Context, cmdStr string) error {
 
 	innerCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	cmd := exec.CommandContext(innerCtx, "sh", "-c", cmdStr) // #nosec
+	cmd := c.command(innerCtx, cmdStr)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
 	}
-	// FIXME
-	// _, err = cmd.StderrPipe()
-	// if err != nil {
-	// 	return err
-	// }
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
 
-	r := stdout.(io.Reader)
+	er := newEOFReader(stdout)
+	r := io.Reader(er)
 
 	err = cmd.Start()
 	if err != nil {
 		return err
 	}
+	go func() {
+		<-innerCtx.Done()
+		// child processes ( e.g. tail -f ) may keep stdout open after the command is killed
+		_ = stdout.Close()
+	}()
+
+	stash := newStderrStash(c.logger)
+	stderrDone := make(chan struct{})
+	go func() {
+		stash.bind(stderr)
+		close(stderrDone)
+	}()
+
+	bindErr := bindReaderAndChan(ctx, c.logger, &r, lineChan, "localhost", c.path, strings.TrimRight(string(tzOut), "\n"))
 
-	bindReaderAndChan(ctx, c.logger, &r, c.lineChan, "localhost", c.path, strings.TrimRight(string(tzOut), "\n"))
-	cancel()
+	if !er.reachedEOF() {
+		// canceled or failed to read
+		cancel()
+		_ = cmd.Wait()
+		c.logger.Info("Close local exec session")
+		return bindErr
+	}
 
+	<-stderrDone
 	err = cmd.Wait()
+	c.logger.Info("Close local exec session")
+	if err != nil && cmd.ProcessState != nil && cmd.ProcessState.Success() {
+		// ctx may be canceled by the reader of lineChan after the command has exited successfully
+		err = nil
+	}
 	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return &ExecError{
+				Host:       "localhost",
+				Path:       c.path,
+				ExitStatus: ee.ExitCode(),
+				Stderr:     stash.String(),
+			}
+		}
 		return err
 	}
 
-	c.logger.Info("Close local exec session")
+	return nil
+}
+
+// output executes cmdStr and returns the output
+func (c *FileClient) output(ctx context.Context, cmdStr string) ([]byte, error) {
+	cmd := c.command(ctx, cmdStr)
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	if err := c.run(ctx, cmd); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// command returns the command running cmdStr with the local shell as the become user
+func (c *FileClient) command(ctx context.Context, cmdStr string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", "-c", pipefailShell, "sh", c.become.wrap(cmdStr)) // #nosec
+	cmd.Stdin = c.become.stdin()
+	return cmd
+}
+
+// run runs cmd, and returns ExecError with stderr if cmd exits with non-zero status
+func (c *FileClient) run(ctx context.Context, cmd *exec.Cmd) error {
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if ee, ok := err.(*exec.ExitError); ok {
+			return &ExecError{
+				Host:       "localhost",
+				Path:       c.path,
+				ExitStatus: ee.ExitCode(),
+				Stderr:     strings.TrimSpace(stderr.String()),
+			}
+		}
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// Filter selects logs by the content. Logs must match all Grep regexps and must not match any GrepV regexp.
+type Filter struct {
+	Grep  []*regexp.Regexp
+	GrepV []*regexp.Regexp
+}
+
+// NewFilter returns Filter. If no regexps are given, returns nil ( nil Filter matches all logs ).
+func NewFilter(grep, grepV []string) (*Filter, error) {
+	f := &Filter{}
+	for _, s := range grep {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		f.Grep = append(f.Grep, re)
+	}
+	for _, s := range grepV {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		f.GrepV = append(f.GrepV, re)
+	}
+	if len(f.Grep) == 0 && len(f.GrepV) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Match reports whether the content ( a line or a multi-line record ) is selected
+func (f *Filter) Match(content string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.Grep {
+		if !re.MatchString(content) {
+			return false
+		}
+	}
+	for _, re := range f.GrepV {
+		if re.MatchString(content) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildGrepCommand returns the pipeline of `grep -F` to filter lines on the remote host ( or "" ).
+// The literals required by the regexps are used, so that the remote filter never excludes the lines that Match selects.
+// The exact match is done by Match after reading.
+func (f *Filter) buildGrepCommand() string {
+	if f == nil {
+		return ""
+	}
+	cmds := []string{}
+	for _, re := range f.Grep {
+		lits, fold, _ := requiredLiterals(re.String())
+		if len(lits) == 0 {
+			continue
+		}
+		cmds = append(cmds, buildGrepFCommand(lits, fold, false))
+	}
+	for _, re := range f.GrepV {
+		// lines can be excluded only when matching the literals is same as matching the regexp
+		lits, fold, exact := requiredLiterals(re.String())
+		if len(lits) == 0 || !exact || fold {
+			continue
+		}
+		cmds = append(cmds, buildGrepFCommand(lits, false, true))
+	}
+	if len(cmds) == 0 {
+		return ""
+	}
+	return " | " + strings.Join(cmds, " | ")
+}
+
+func buildGrepFCommand(lits []string, fold, invert bool) string {
+	opts := "-aF"
+	if fold {
+		opts = opts + "i"
+	}
+	if invert {
+		opts = opts + "v"
+	}
+	args := []string{}
+	for _, l := range lits {
+		args = append(args, fmt.Sprintf("-e %s", shellQuote(l)))
+	}
+	// grep exits with status 1 when no lines are selected
+	return fmt.Sprintf("{ grep %s %s || test $? -eq 1; }", opts, strings.Join(args, " "))
+}
+
+// requiredLiterals returns the literals that one of them is contained in every line matching the regexp.
+// exact is true if a line matches the regexp when it contains one of the literals.
+func requiredLiterals(s string) (lits []string, fold bool, exact bool) {
+	re, err := syntax.Parse(s, syntax.Perl)
+	if err != nil {
+		return nil, false, false
+	}
+	return literalsOf(re.Simplify())
+}
+
+func literalsOf(re *syntax.Regexp) ([]string, bool, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		lit := string(re.Rune)
+		fold := re.Flags&syntax.FoldCase != 0
+		if strings.Contains(lit, "\n") || (fold && !isASCII(lit)) {
+			return nil, false, false
+		}
+		if fold {
+			lit = strings.ToLower(lit)
+		}
+		return []string{lit}, fold, true
+	case syntax.OpCapture:
+		return literalsOf(re.Sub[0])
+	case syntax.OpConcat:
+		// the longest literal in the concatenation
+		var (
+			longest []string
+			fold    bool
+		)
+		for _, sub := range re.Sub {
+			lits, f, _ := literalsOf(sub)
+			if len(lits) == 1 && (longest == nil || len(lits[0]) > len(longest[0])) {
+				longest = lits
+				fold = f
+			}
+		}
+		return longest, fold, false
+	case syntax.OpAlternate:
+		lits := []string{}
+		fold := false
+		exact := true
+		for _, sub := range re.Sub {
+			l, f, e := literalsOf(sub)
+			if len(l) == 0 {
+				return nil, false, false
+			}
+			lits = append(lits, l...)
+			fold = fold || f
+			exact = exact && e
+		}
+		return lits, fold, exact
+	}
+	return nil, false, false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
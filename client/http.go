@@ -0,0 +1,394 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const httpPollInterval = 1 * time.Second
+
+var hrefRe = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+// HTTPClient reads logs exposed over HTTP(S)
+type HTTPClient struct {
+	base     *url.URL
+	host     string
+	path     string
+	http     *http.Client
+	lineChan chan Line
+	logger   *zap.Logger
+}
+
+// httpFile ...
+type httpFile struct {
+	url     *url.URL
+	size    int64
+	modTime time.Time
+}
+
+// NewHTTPClient returns HTTPClient.
+// If the last element of the source path has glob patterns ( or is empty ), files are listed from the index page of the directory.
+func NewHTTPClient(l *zap.Logger, source string) (Client, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupport scheme: %s", u.Scheme)
+	}
+	return &HTTPClient{
+		base:     u,
+		host:     u.Hostname(),
+		path:     u.Path,
+		http:     &http.Client{},
+		lineChan: make(chan Line),
+		logger:   l,
+	}, nil
+}
+
+// Read ...
+func (c *HTTPClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	files, err := c.find(ctx, st)
+	if err != nil {
+		return err
+	}
+	return bindWriterFuncAndChan(ctx, c.logger, c.lineChan, c.host, c.path, "", func(w io.Writer) error {
+		for _, f := range files {
+			err := c.cat(ctx, w, f)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Tailf polls the latest file using Range requests
+func (c *HTTPClient) Tailf(ctx context.Context) error {
+	files, err := c.find(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no such file: %s", c.base.String())
+	}
+	f := files[len(files)-1]
+	return bindWriterFuncAndChan(ctx, c.logger, c.lineChan, c.host, c.path, "", func(w io.Writer) error {
+		offset := f.size
+		if offset < 0 {
+			offset = 0
+		}
+		ticker := time.NewTicker(httpPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			n, err := c.readFrom(ctx, w, f.url, offset)
+			if err != nil {
+				return err
+			}
+			if n < 0 {
+				// truncated
+				offset = 0
+				continue
+			}
+			offset += n
+		}
+	})
+}
+
+// Ls ...
+func (c *HTTPClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	files, err := c.find(ctx, st)
+	if err != nil {
+		return err
+	}
+	return bindWriterFuncAndChan(ctx, c.logger, c.lineChan, c.host, c.path, "", func(w io.Writer) error {
+		for _, f := range files {
+			_, err := fmt.Fprintln(w, f.url.Path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Copy downloads the raw file
+func (c *HTTPClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	dstLogFilePath := filepath.Join(dstDir, c.host, filePath)
+	dstLogDir := filepath.Dir(dstLogFilePath)
+	err := os.MkdirAll(dstLogDir, 0755) // #nosec
+	if err != nil {
+		return err
+	}
+	u := *c.base
+	u.Path = filePath
+	res, err := c.get(ctx, &u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	dst, err := os.Create(dstLogFilePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, res.Body)
+	return err
+}
+
+// RandomOne ...
+func (c *HTTPClient) RandomOne(ctx context.Context) error {
+	files, err := c.find(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return bindWriterFuncAndChan(ctx, c.logger, c.lineChan, c.host, c.path, "", func(w io.Writer) error {
+		return readRandomLine(w, len(files), func(i int) (io.ReadCloser, error) {
+			res, err := c.get(ctx, files[i].url)
+			if err != nil {
+				return nil, err
+			}
+			return res.Body, nil
+		})
+	})
+}
+
+// Out ...
+func (c *HTTPClient) Out() <-chan Line {
+	return c.lineChan
+}
+
+// find returns files matching the source modified after st, in order of modification time
+func (c *HTTPClient) find(ctx context.Context, st *time.Time) ([]httpFile, error) {
+	base := path.Base(c.path)
+	if strings.HasSuffix(c.path, "/") {
+		base = ""
+	}
+	urls := []*url.URL{}
+	if base != "" && !strings.ContainsAny(base, "*?[") {
+		u := *c.base
+		urls = append(urls, &u)
+	} else {
+		dir := *c.base
+		dir.Path = path.Dir(c.path) + "/"
+		if base == "" {
+			dir.Path = c.path
+			base = "*"
+		}
+		links, err := c.index(ctx, &dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range links {
+			matched, err := path.Match(base, path.Base(u.Path))
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				urls = append(urls, u)
+			}
+		}
+	}
+
+	files := []httpFile{}
+	for _, u := range urls {
+		f, err := c.head(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		if st != nil && !f.modTime.IsZero() && !f.modTime.After(*st) {
+			continue
+		}
+		files = append(files, *f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, nil
+}
+
+// index returns links to files in the directory from the index page
+func (c *HTTPClient) index(ctx context.Context, dir *url.URL) ([]*url.URL, error) {
+	res, err := c.get(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	links := []*url.URL{}
+	seen := map[string]struct{}{}
+	for _, m := range hrefRe.FindAllStringSubmatch(string(b), -1) {
+		ref, err := url.Parse(m[1])
+		if err != nil {
+			continue
+		}
+		u := dir.ResolveReference(ref)
+		// only files just under the directory
+		if u.Host != dir.Host || u.RawQuery != "" || path.Dir(u.Path)+"/" != dir.Path || strings.HasSuffix(u.Path, "/") {
+			continue
+		}
+		if _, ok := seen[u.Path]; ok {
+			continue
+		}
+		seen[u.Path] = struct{}{}
+		links = append(links, u)
+	}
+	return links, nil
+}
+
+func (c *HTTPClient) head(ctx context.Context, u *url.URL) (*httpFile, error) {
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.ContentLength < 0 {
+		// some servers do not support HEAD ( e.g. 405 ) or do not return Content-Length, so get the file
+		return c.stat(ctx, u)
+	}
+	return newHTTPFile(u, res, res.ContentLength), nil
+}
+
+// stat returns the size and the modification time of the file by GET
+func (c *HTTPClient) stat(ctx context.Context, u *url.URL) (*httpFile, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// the size of the raw data
+	req.Header.Set("Accept-Encoding", "identity")
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error (%s): %s", u.String(), res.Status)
+	}
+	size := res.ContentLength
+	if size < 0 {
+		size, err = io.Copy(ioutil.Discard, res.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newHTTPFile(u, res, size), nil
+}
+
+func newHTTPFile(u *url.URL, res *http.Response, size int64) *httpFile {
+	f := &httpFile{
+		url:  u,
+		size: size,
+	}
+	if lm := res.Header.Get("Last-Modified"); lm != "" {
+		if t, err := http.ParseTime(lm); err == nil {
+			f.modTime = t
+		}
+	}
+	return f
+}
+
+func (c *HTTPClient) get(ctx context.Context, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("HTTP error (%s): %s", u.String(), res.Status)
+	}
+	return res, nil
+}
+
+// cat writes the decompressed file to w
+func (c *HTTPClient) cat(ctx context.Context, w io.Writer, f httpFile) error {
+	res, err := c.get(ctx, f.url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	r, err := newDecompressReader(res.Body)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// readFrom writes data of the file after offset to w, and returns the size of written data ( -1 if the file is truncated )
+func (c *HTTPClient) readFrom(ctx context.Context, w io.Writer, u *url.URL, offset int64) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	// Range is applied to the raw data
+	req.Header.Set("Accept-Encoding", "identity")
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		return io.Copy(w, res.Body)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Content-Range: bytes */<size>
+		cr := res.Header.Get("Content-Range")
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			size, err := strconv.ParseInt(cr[i+1:], 10, 64)
+			if err == nil && size < offset {
+				return -1, nil
+			}
+		}
+		return 0, nil
+	case http.StatusOK:
+		// Range is not supported by the server
+		if res.ContentLength >= 0 && res.ContentLength < offset {
+			return -1, nil
+		}
+		_, err := io.CopyN(ioutil.Discard, res.Body, offset)
+		if err != nil {
+			if err == io.EOF {
+				return -1, nil
+			}
+			return 0, err
+		}
+		return io.Copy(w, res.Body)
+	default:
+		return 0, fmt.Errorf("HTTP error (%s): %s", u.String(), res.Status)
+	}
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// JournalClient reads logs of systemd units from journald
+type JournalClient struct {
+	execRunner
+	unit string
+}
+
+// NewJournalClient returns JournalClient executing journalctl via ec ( SSHClient or FileClient )
+func NewJournalClient(l *zap.Logger, path string, ec Client) (Client, error) {
+	unit := strings.TrimPrefix(path, "/")
+	if unit == "" || strings.Contains(unit, "/") {
+		return nil, fmt.Errorf("invalid journal source path: %s", path)
+	}
+	e, ok := ec.(execClient)
+	if !ok {
+		return nil, fmt.Errorf("client can not execute journalctl: %T", ec)
+	}
+	return &JournalClient{
+		execRunner: execRunner{
+			exec:     e,
+			lineChan: make(chan Line),
+			logger:   l,
+		},
+		unit: unit,
+	}, nil
+}
+
+// Read ...
+func (c *JournalClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	cmd := fmt.Sprintf("journalctl -o json --no-pager -u %s --since '@%d' --until '@%d'", shellQuote(c.unit), st.Unix(), et.Unix()+1)
+	return c.run(ctx, cmd, c.bindEntries)
+}
+
+// Tailf ...
+func (c *JournalClient) Tailf(ctx context.Context) error {
+	cmd := fmt.Sprintf("journalctl -o json --no-pager -u %s -f -n 0", shellQuote(c.unit))
+	return c.run(ctx, cmd, c.bindEntries)
+}
+
+// Ls ...
+func (c *JournalClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	pattern := c.unit
+	if !strings.ContainsAny(pattern, ".*?[") {
+		// same as journalctl -u
+		pattern = fmt.Sprintf("%s.service", pattern)
+	}
+	cmd := fmt.Sprintf("systemctl list-units --all --plain --no-legend --no-pager %s", shellQuote(pattern))
+	return c.run(ctx, cmd, func(in <-chan Line) {
+		for line := range in {
+			fields := strings.Fields(line.Content)
+			if len(fields) == 0 {
+				continue
+			}
+			p := fmt.Sprintf("/%s", fields[0])
+			c.lineChan <- Line{
+				Host:     line.Host,
+				Path:     p,
+				Content:  p,
+				TimeZone: "",
+			}
+		}
+	})
+}
+
+// Copy ...
+func (c *JournalClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	return fmt.Errorf("not supported: copy journal sources: %s", filePath)
+}
+
+// RandomOne ...
+func (c *JournalClient) RandomOne(ctx context.Context) error {
+	cmd := fmt.Sprintf("journalctl -o json --no-pager -u %s -n 1", shellQuote(c.unit))
+	return c.run(ctx, cmd, c.bindEntries)
+}
+
+// Out ...
+func (c *JournalClient) Out() <-chan Line {
+	return c.lineChan
+}
+
+// bindEntries converts entries of `journalctl -o json` to Line
+func (c *JournalClient) bindEntries(in <-chan Line) {
+	for line := range in {
+		l, err := parseJournalEntry(line.Content)
+		if err != nil {
+			c.logger.Error("Failed to parse journal entry", zap.Error(err))
+			continue
+		}
+		l.Host = line.Host
+		c.lineChan <- *l
+	}
+}
+
+// parseJournalEntry parses a line of `journalctl -o json`
+func parseJournalEntry(s string) (*Line, error) {
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &entry); err != nil {
+		return nil, err
+	}
+	us, err := strconv.ParseInt(journalValue(entry["__REALTIME_TIMESTAMP"]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid __REALTIME_TIMESTAMP: %s", s)
+	}
+	ts := time.Unix(0, us*int64(time.Microsecond))
+
+	// UNIT is set to the messages about the unit logged by systemd
+	unit := journalValue(entry["UNIT"])
+	if unit == "" {
+		unit = journalValue(entry["_SYSTEMD_UNIT"])
+	}
+	fields := map[string]string{}
+	for k, v := range map[string]string{
+		"priority":   journalValue(entry["PRIORITY"]),
+		"unit":       unit,
+		"pid":        journalValue(entry["_PID"]),
+		"identifier": journalValue(entry["SYSLOG_IDENTIFIER"]),
+		"cursor":     journalValue(entry["__CURSOR"]),
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+
+	return &Line{
+		Path:               fmt.Sprintf("/%s", unit),
+		Content:            journalValue(entry["MESSAGE"]),
+		TimeZone:           "",
+		TimestampViaClient: &ts,
+		Fields:             fields,
+	}, nil
+}
+
+// journalValue returns the field value as string. Non UTF-8 values are serialized as arrays of bytes.
+func journalValue(v interface{}) string {
+	switch vv := v.(type) {
+	case string:
+		return vv
+	case []interface{}:
+		b := make([]byte, 0, len(vv))
+		for _, n := range vv {
+			f, ok := n.(float64)
+			if !ok {
+				return ""
+			}
+			b = append(b, byte(f))
+		}
+		return string(b)
+	default:
+		return ""
+	}
+}
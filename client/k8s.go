@@ -5,8 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/k1LoW/harvest/client/k8s"
@@ -19,44 +23,154 @@ import (
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
+// k8sPreviousSuffix is the suffix of the path of logs of the previous ( terminated ) container
+const k8sPreviousSuffix = "#previous"
+
 type K8sClient struct {
 	contextName string
 	namespace   string
-	pod         string
 	podFilter   *regexp.Regexp
+	selector    string
+	container   *k8s.ContainerFilter
+	previous    bool
+	limitBytes  int64
+	stats       *k8sReadStats
 	clientset   *kubernetes.Clientset
 	lineChan    chan Line
 	logger      *zap.Logger
 }
 
+// K8sOption ...
+type K8sOption func(*K8sClient) error
+
+// K8sPrevious enable reading logs of the previous containers of restarted containers
+func K8sPrevious(previous bool) K8sOption {
+	return func(c *K8sClient) error {
+		c.previous = previous
+		return nil
+	}
+}
+
+// K8sSelector set the label selector of pods ( e.g. app=api,tier!=canary )
+func K8sSelector(selector string) K8sOption {
+	return func(c *K8sClient) error {
+		if err := k8s.ValidateSelector(selector); err != nil {
+			return err
+		}
+		c.selector = selector
+		return nil
+	}
+}
+
+// K8sContainerFilter set the filter of containers ( comma separated globs of the container name, globs with `!` exclude containers )
+func K8sContainerFilter(filter string) K8sOption {
+	return func(c *K8sClient) error {
+		f, err := k8s.NewContainerFilter(filter)
+		if err != nil {
+			return err
+		}
+		c.container = f
+		return nil
+	}
+}
+
+// K8sLimitBytes set the limit of bytes of logs read from each container
+func K8sLimitBytes(limitBytes int64) K8sOption {
+	return func(c *K8sClient) error {
+		if limitBytes < 0 {
+			return fmt.Errorf("invalid k8s limit bytes: %d", limitBytes)
+		}
+		c.limitBytes = limitBytes
+		return nil
+	}
+}
+
 // NewK8sClient ...
-func NewK8sClient(l *zap.Logger, host, path string) (Client, error) {
+func NewK8sClient(l *zap.Logger, host, path string, opts ...K8sOption) (Client, error) {
 	contextName := host
-	splited := strings.Split(path, "/")
-	ns := splited[1]
-	p := splited[2]
-	pRegexp := regexp.MustCompile(strings.Replace(strings.Replace(p, ".*", "*", -1), "*", ".*", -1))
+	ns, pRegexp, err := k8s.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
 
 	clientset, err := k8s.NewKubeClientSet(contextName)
 	if err != nil {
 		return nil, err
 	}
 
-	return &K8sClient{
+	c := &K8sClient{
 		contextName: contextName,
 		namespace:   ns,
-		pod:         p,
 		podFilter:   pRegexp,
 		clientset:   clientset,
 		lineChan:    make(chan Line),
 		logger:      l,
-	}, nil
+	}
+	for _, opt := range opts {
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
-// Read ...
+// Read reads logs of each container from st, and stops reading the container when the logs reach et
 func (c *K8sClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
-	sinceSeconds := time.Now().Unix() - st.Unix()
-	return c.Stream(ctx, false, &sinceSeconds, nil)
+	opts := &corev1.PodLogOptions{
+		SinceTime: &metav1.Time{Time: *st},
+	}
+	if c.limitBytes > 0 {
+		opts.LimitBytes = &c.limitBytes
+	}
+	stats := &k8sReadStats{}
+	c.stats = stats
+	err := c.stream(ctx, opts, et, stats)
+	if err != nil {
+		return err
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	for _, r := range stats.skipped {
+		c.logger.Info(fmt.Sprintf("Skipped logs after the end time: %s", r))
+	}
+	if stats.truncated > 0 {
+		c.logger.Warn(fmt.Sprintf("Logs of %d/%d containers are truncated by the limit of %d bytes", stats.truncated, stats.containers, c.limitBytes))
+	}
+	c.logger.Debug(fmt.Sprintf("Read %d bytes from %d containers", stats.bytes, stats.containers))
+	return nil
+}
+
+// Transferred returns the bytes of logs read from Kubernetes API by Read ( ok is false before Read )
+func (c *K8sClient) Transferred() (int64, int64, bool) {
+	if c.stats == nil {
+		return 0, 0, false
+	}
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return c.stats.bytes, c.stats.bytes, true
+}
+
+// Note returns the number of containers whose logs are stopped at the end time or truncated by the limit by Read
+func (c *K8sClient) Note() string {
+	if c.stats == nil {
+		return ""
+	}
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	notes := []string{}
+	if len(c.stats.skipped) > 0 {
+		ranges := []string{}
+		for _, r := range c.stats.skipped {
+			ranges = append(ranges, r.String())
+		}
+		sort.Strings(ranges)
+		notes = append(notes, fmt.Sprintf("stopped at end time: %d/%d containers ( skipped %s )", len(c.stats.skipped), c.stats.containers, strings.Join(ranges, ", ")))
+	}
+	if c.stats.truncated > 0 {
+		notes = append(notes, fmt.Sprintf("truncated by k8sLimitBytes: %d/%d containers", c.stats.truncated, c.stats.containers))
+	}
+	return strings.Join(notes, ", ")
 }
 
 // Tailf ...
@@ -71,28 +185,90 @@ func (c *K8sClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error
 		c.logger.Debug("Close chan client.Line")
 		close(c.lineChan)
 	}()
-	list, err := c.clientset.CoreV1().Pods(c.namespace).List(metav1.ListOptions{})
+	list, err := c.clientset.CoreV1().Pods(c.namespace).List(metav1.ListOptions{LabelSelector: c.selector})
 	if err != nil {
 		return err
 	}
 	for _, i := range list.Items {
+		if !c.podFilter.MatchString(i.GetName()) {
+			continue
+		}
+		restarted := map[string]bool{}
+		for _, s := range i.Status.ContainerStatuses {
+			restarted[s.Name] = s.RestartCount > 0
+		}
 		for _, container := range i.Spec.Containers {
+			if !c.container.Match(container.Name) {
+				continue
+			}
 			l := strings.Join([]string{"", i.GetNamespace(), i.GetName(), container.Name}, "/")
-			c.lineChan <- Line{
-				Host:     c.contextName,
-				Path:     l,
-				Content:  fmt.Sprintf("%s STDOUT/STDERR", l),
-				TimeZone: "",
+			paths := []string{l}
+			if c.previous && restarted[container.Name] {
+				paths = append(paths, l+k8sPreviousSuffix)
+			}
+			for _, p := range paths {
+				c.lineChan <- Line{
+					Host:     c.contextName,
+					Path:     p,
+					Content:  fmt.Sprintf("%s STDOUT/STDERR", p),
+					TimeZone: "",
+				}
 			}
 		}
 	}
 	return nil
 }
 
-// Copy ...
-func (c *K8sClient) Copy(ctx context.Context, filePath string, dstDir string) error {
-	c.logger.Error("not implemented: cp k8s sources")
-	return nil
+// Copy writes logs of the container between st and et to <dstDir>/<context>/<namespace>/<pod>/<container>.log ( <container>.previous.log for the previous container )
+func (c *K8sClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	// filePath is the content of Ls ( /namespace/pod/container STDOUT/STDERR )
+	fields := strings.Fields(filePath)
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid k8s container path: %s", filePath)
+	}
+	p := fields[0]
+	previous := strings.HasSuffix(p, k8sPreviousSuffix)
+	p = strings.TrimSuffix(p, k8sPreviousSuffix)
+	splited := strings.Split(p, "/")
+	if len(splited) != 4 {
+		return fmt.Errorf("invalid k8s container path: %s", filePath)
+	}
+	namespace, pod, container := splited[1], splited[2], splited[3]
+
+	fileName := fmt.Sprintf("%s.log", container)
+	if previous {
+		fileName = fmt.Sprintf("%s.previous.log", container)
+	}
+	dstLogFilePath := filepath.Join(dstDir, c.contextName, namespace, pod, fileName)
+	err := os.MkdirAll(filepath.Dir(dstLogFilePath), 0755) // #nosec
+	if err != nil {
+		return err
+	}
+
+	opts := &corev1.PodLogOptions{
+		Timestamps: true,
+		Container:  container,
+		Previous:   previous,
+	}
+	if st != nil {
+		opts.SinceTime = &metav1.Time{Time: *st}
+	}
+	stream, err := c.clientset.CoreV1().Pods(namespace).GetLogs(pod, opts).Stream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	go func() {
+		<-ctx.Done()
+		_ = stream.Close()
+	}()
+
+	dst, err := os.Create(dstLogFilePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	return copyK8sLogs(dst, stream, et)
 }
 
 // RandomOne ...
@@ -106,6 +282,37 @@ func (c *K8sClient) Out() <-chan Line {
 	return c.lineChan
 }
 
+// copyK8sLogs writes the content of the logs with timestamps ( `Timestamps: true` ) until et to w
+func copyK8sLogs(w io.Writer, r io.Reader, et *time.Time) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			splitted := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 2)
+			ts, perr := time.Parse(time.RFC3339Nano, splitted[0])
+			if perr == nil && et != nil && ts.After(*et) {
+				return nil
+			}
+			content := strings.TrimSuffix(line, "\n")
+			if perr == nil {
+				content = ""
+				if len(splitted) == 2 {
+					content = splitted[1]
+				}
+			}
+			if _, werr := fmt.Fprintln(w, content); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
 // Reference code:
 // https://github.com/wercker/stern/blob/473d1b605673d8f4bfe5f86b3748d02c87d339d7/stern/main.go
 // https://github.com/wercker/stern/blob/473d1b605673d8f4bfe5f86b3748d02c87d339d7/stern/tail.go
@@ -129,67 +336,131 @@ type targetContainer struct {
 	namespace string
 	pod       string
 	container string
+	previous  bool
 }
 
 func (tc *targetContainer) getID() string {
+	if tc.previous {
+		return fmt.Sprintf("%s-%s-%s%s", tc.namespace, tc.pod, tc.container, k8sPreviousSuffix)
+	}
 	return fmt.Sprintf("%s-%s-%s", tc.namespace, tc.pod, tc.container)
 }
 
+// k8sReadStats is the stats of reading logs of containers
+type k8sReadStats struct {
+	mu         sync.Mutex
+	containers int
+	skipped    []k8sSkippedRange // stopped at the end time
+	truncated  int               // truncated by LimitBytes
+	bytes      int64
+}
+
+// k8sSkippedRange is the range of logs of the container not read after the end time
+type k8sSkippedRange struct {
+	path     string
+	from, to time.Time
+}
+
+func (r k8sSkippedRange) String() string {
+	return fmt.Sprintf("%s %s - %s", r.path, r.from.Format(time.RFC3339), r.to.Format(time.RFC3339))
+}
+
+func (s *k8sReadStats) add(bytes int64, skipped *k8sSkippedRange, truncated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers++
+	s.bytes += bytes
+	if skipped != nil {
+		s.skipped = append(s.skipped, *skipped)
+	}
+	if truncated {
+		s.truncated++
+	}
+}
+
 // Stream ...
 func (c *K8sClient) Stream(ctx context.Context, follow bool, sinceSeconds, tailLines *int64) error {
+	return c.stream(ctx, &corev1.PodLogOptions{
+		Follow:       follow,
+		SinceSeconds: sinceSeconds,
+		TailLines:    tailLines,
+	}, nil, &k8sReadStats{})
+}
+
+// stream reads logs of the containers with opts until et
+func (c *K8sClient) stream(ctx context.Context, opts *corev1.PodLogOptions, et *time.Time, stats *k8sReadStats) error {
+	follow := opts.Follow
 	defer func() {
 		c.logger.Debug("Close chan client.Line")
 		close(c.lineChan)
 	}()
 	innerCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	added, removed, err := watchContainers(innerCtx, c.clientset.CoreV1().Pods(c.namespace), c.podFilter)
+	// the previous containers do not output new logs
+	previous := c.previous && !follow
+	added, removed, err := watchContainers(innerCtx, c.clientset.CoreV1().Pods(c.namespace), c.podFilter, c.selector, c.container, previous)
 	if err != nil {
 		return err
 	}
 
-	tails := make(map[string]*Tail)
+	var (
+		mu    sync.Mutex
+		tails = make(map[string]*Tail)
+		// finished tails are not started again on reading logs, because the containers are added again on every Modified event
+		finished = make(map[string]struct{})
+	)
 
 	go func() {
 		for tc := range added {
 			id := tc.getID()
-			if tails[id] != nil {
+			mu.Lock()
+			if _, ok := finished[id]; tails[id] != nil || ok {
+				mu.Unlock()
 				continue
 			}
 
 			tail := NewTail(c.logger, c.lineChan, c.contextName, tc.namespace, tc.pod, tc.container)
+			tail.Previous = tc.previous
+			tail.stats = stats
 			tails[id] = tail
+			mu.Unlock()
 
-			tail.Start(innerCtx, c.clientset.CoreV1().Pods(tc.namespace), follow, sinceSeconds, tailLines)
+			tail.Start(innerCtx, c.clientset.CoreV1().Pods(tc.namespace), opts, et)
 		}
 	}()
 
 	go func() {
 		for tc := range removed {
 			id := tc.getID()
-			if tails[id] == nil {
-				delete(tails, id)
-				continue
+			mu.Lock()
+			if tails[id] != nil {
+				tails[id].Close()
 			}
-			tails[id].Close()
 			delete(tails, id)
+			mu.Unlock()
 		}
 	}()
 
 	go func() {
 		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
 	L:
 		for {
 			select {
 			case <-ticker.C:
+				mu.Lock()
 				for id, t := range tails {
-					if t.Closed {
+					if t.done() {
 						delete(tails, id)
+						if !follow {
+							finished[id] = struct{}{}
+						}
 					}
 				}
 				if len(tails) == 0 {
 					cancel()
 				}
+				mu.Unlock()
 			case <-innerCtx.Done():
 				break L
 			}
@@ -201,8 +472,8 @@ func (c *K8sClient) Stream(ctx context.Context, follow bool, sinceSeconds, tailL
 	return nil
 }
 
-func watchContainers(ctx context.Context, i v1.PodInterface, podFilter *regexp.Regexp) (chan *targetContainer, chan *targetContainer, error) {
-	watcher, err := i.Watch(metav1.ListOptions{Watch: true})
+func watchContainers(ctx context.Context, i v1.PodInterface, podFilter *regexp.Regexp, selector string, containerFilter *k8s.ContainerFilter, previous bool) (chan *targetContainer, chan *targetContainer, error) {
+	watcher, err := i.Watch(metav1.ListOptions{Watch: true, LabelSelector: selector})
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "failed to set up watch")
 	}
@@ -232,11 +503,22 @@ func watchContainers(ctx context.Context, i v1.PodInterface, podFilter *regexp.R
 					statuses = append(statuses, pod.Status.ContainerStatuses...)
 
 					for _, c := range statuses {
+						if !containerFilter.Match(c.Name) {
+							continue
+						}
 						added <- &targetContainer{
 							namespace: pod.Namespace,
 							pod:       pod.Name,
 							container: c.Name,
 						}
+						if previous && c.RestartCount > 0 {
+							added <- &targetContainer{
+								namespace: pod.Namespace,
+								pod:       pod.Name,
+								container: c.Name,
+								previous:  true,
+							}
+						}
 					}
 				case watch.Deleted:
 					var containers []corev1.Container
@@ -244,11 +526,22 @@ func watchContainers(ctx context.Context, i v1.PodInterface, podFilter *regexp.R
 					containers = append(containers, pod.Spec.InitContainers...)
 
 					for _, c := range containers {
+						if !containerFilter.Match(c.Name) {
+							continue
+						}
 						removed <- &targetContainer{
 							namespace: pod.Namespace,
 							pod:       pod.Name,
 							container: c.Name,
 						}
+						if previous {
+							removed <- &targetContainer{
+								namespace: pod.Namespace,
+								pod:       pod.Name,
+								container: c.Name,
+								previous:  true,
+							}
+						}
 					}
 				}
 			case <-ctx.Done():
@@ -268,9 +561,12 @@ type Tail struct {
 	Namespace     string
 	PodName       string
 	ContainerName string
+	Previous      bool
 	Closed        bool
+	stats         *k8sReadStats
 	lineChan      chan Line
 	closed        chan struct{}
+	closeOnce     sync.Once
 	logger        *zap.Logger
 }
 
@@ -287,28 +583,33 @@ func NewTail(l *zap.Logger, lineChan chan Line, contextName, namespace, podName,
 	}
 }
 
-// Start starts tailing
-func (t *Tail) Start(ctx context.Context, i v1.PodInterface, follow bool, sinceSeconds, tailLines *int64) {
+// Start starts tailing. If et is set, tailing stops when the logs reach et.
+func (t *Tail) Start(ctx context.Context, i v1.PodInterface, opts *corev1.PodLogOptions, et *time.Time) {
 	go func() {
-		t.logger.Debug(fmt.Sprintf("Open stream: /%s/%s/%s", t.Namespace, t.PodName, t.ContainerName))
-		req := i.GetLogs(t.PodName, &corev1.PodLogOptions{
-			Follow:       follow,
-			Timestamps:   true,
-			Container:    t.ContainerName,
-			SinceSeconds: sinceSeconds,
-			TailLines:    tailLines,
-		})
+		t.logger.Debug(fmt.Sprintf("Open stream: %s", t.path()))
+		o := *opts
+		o.Timestamps = true
+		o.Container = t.ContainerName
+		o.Previous = t.Previous
+		req := i.GetLogs(t.PodName, &o)
 
 		stream, err := req.Stream()
 		if err != nil {
-			t.logger.Error(fmt.Sprintf("Error opening stream to /%s/%s/%s", t.Namespace, t.PodName, t.ContainerName))
+			t.logger.Error(fmt.Sprintf("Error opening stream to %s", t.path()))
+			t.Close()
 			return
 		}
+		defer stream.Close()
 
+		var (
+			read    int64
+			skipped *k8sSkippedRange
+		)
 		reader := bufio.NewReader(stream)
 	L:
 		for {
 			line, err := reader.ReadBytes('\n')
+			read += int64(len(line))
 			if err != nil {
 				if err != io.EOF {
 					t.logger.Error(fmt.Sprintf("%s", err))
@@ -321,6 +622,10 @@ func (t *Tail) Start(ctx context.Context, i v1.PodInterface, follow bool, sinceS
 			ts, err := time.Parse(time.RFC3339Nano, splitted[0])
 			if err != nil {
 				t.logger.Error(fmt.Sprintf("%s", err))
+			} else if et != nil && ts.After(*et) {
+				// stop reading the container ( the rest of the logs is not transferred )
+				skipped = &k8sSkippedRange{path: t.path(), from: ts, to: time.Now().UTC()}
+				break L
 			}
 
 			select {
@@ -329,20 +634,44 @@ func (t *Tail) Start(ctx context.Context, i v1.PodInterface, follow bool, sinceS
 			default:
 				t.lineChan <- Line{
 					Host:               t.ContextName,
-					Path:               strings.Join([]string{"", t.Namespace, t.PodName, t.ContainerName}, "/"),
+					Path:               t.path(),
 					Content:            strings.Join(splitted[1:], " "),
 					TimeZone:           "",
 					TimestampViaClient: &ts,
 				}
 			}
 		}
+		if t.stats != nil {
+			t.stats.add(read, skipped, o.LimitBytes != nil && read >= *o.LimitBytes)
+		}
 		t.Close()
 	}()
 }
 
+// path returns /namespace/pod/container ( with #previous for the previous container )
+func (t *Tail) path() string {
+	p := strings.Join([]string{"", t.Namespace, t.PodName, t.ContainerName}, "/")
+	if t.Previous {
+		p += k8sPreviousSuffix
+	}
+	return p
+}
+
+// done reports whether tailing is stopped
+func (t *Tail) done() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close stops tailing
 func (t *Tail) Close() {
-	t.logger.Debug(fmt.Sprintf("Close stream to /%s/%s/%s", t.Namespace, t.PodName, t.ContainerName))
-	t.Closed = true
-	close(t.closed)
+	t.closeOnce.Do(func() {
+		t.logger.Debug(fmt.Sprintf("Close stream to %s", t.path()))
+		t.Closed = true
+		close(t.closed)
+	})
 }
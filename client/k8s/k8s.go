@@ -1,7 +1,9 @@
 package k8s
 
 import (
+	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -12,6 +14,7 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/azure"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
@@ -48,12 +51,84 @@ func GetCurrentContext() (string, error) {
 	return rc.CurrentContext, nil
 }
 
-func GetContainers(contextName string, namespace string, podFilter *regexp.Regexp) ([]string, error) {
+// ParsePath returns the namespace and the pod name filter of the path of k8s sources ( /namespace/pod-name* )
+func ParsePath(p string) (string, *regexp.Regexp, error) {
+	splited := strings.Split(p, "/")
+	if len(splited) < 2 || splited[1] == "" || len(splited) > 3 {
+		return "", nil, fmt.Errorf("invalid k8s source path: %s", p)
+	}
+	pod := "*"
+	if len(splited) == 3 && splited[2] != "" {
+		pod = splited[2]
+	}
+	podFilter, err := regexp.Compile(strings.Replace(strings.Replace(pod, ".*", "*", -1), "*", ".*", -1))
+	if err != nil {
+		return "", nil, err
+	}
+	return splited[1], podFilter, nil
+}
+
+// ValidateSelector validates the label selector ( e.g. app=api,tier!=canary )
+func ValidateSelector(selector string) error {
+	_, err := labels.Parse(selector)
+	return err
+}
+
+// ContainerFilter filters containers by comma separated globs of the container name ( globs with `!` exclude containers )
+type ContainerFilter struct {
+	include []string
+	exclude []string
+}
+
+// NewContainerFilter returns ContainerFilter ( e.g. `app,worker-*`, `!istio-proxy` )
+func NewContainerFilter(s string) (*ContainerFilter, error) {
+	f := &ContainerFilter{}
+	for _, g := range strings.Split(s, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		exclude := strings.HasPrefix(g, "!")
+		g = strings.TrimPrefix(g, "!")
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("invalid container filter: %s", s)
+		}
+		if exclude {
+			f.exclude = append(f.exclude, g)
+		} else {
+			f.include = append(f.include, g)
+		}
+	}
+	return f, nil
+}
+
+// Match ...
+func (f *ContainerFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, g := range f.exclude {
+		if matched, _ := path.Match(g, name); matched {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, g := range f.include {
+		if matched, _ := path.Match(g, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func GetContainers(contextName string, namespace string, podFilter *regexp.Regexp, selector string, containerFilter *ContainerFilter) ([]string, error) {
 	clientset, err := NewKubeClientSet(contextName)
 	if err != nil {
 		return nil, err
 	}
-	list, err := clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+	list, err := clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +138,9 @@ func GetContainers(contextName string, namespace string, podFilter *regexp.Regex
 			continue
 		}
 		for _, c := range i.Spec.Containers {
+			if !containerFilter.Match(c.Name) {
+				continue
+			}
 			containers = append(containers, strings.Join([]string{"", i.GetNamespace(), i.GetName(), c.Name}, "/"))
 		}
 	}
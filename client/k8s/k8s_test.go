@@ -0,0 +1,69 @@
+package k8s
+
+import (
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	var tests = []struct {
+		path          string
+		wantNamespace string
+		wantMatch     string
+		wantErr       bool
+	}{
+		{"/default/api-*", "default", "api-5d8f7c9b6-x2k4z", false},
+		{"/default", "default", "web-0", false},
+		{"/default/", "default", "web-0", false},
+		{"/", "", "", true},
+		{"/default/api-*/app", "", "", true},
+	}
+	for _, tt := range tests {
+		namespace, podFilter, err := ParsePath(tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error", tt.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if namespace != tt.wantNamespace {
+			t.Errorf("\ngot %v\nwant %v", namespace, tt.wantNamespace)
+		}
+		if !podFilter.MatchString(tt.wantMatch) {
+			t.Errorf("%s: %s does not match", tt.path, tt.wantMatch)
+		}
+	}
+}
+
+func TestContainerFilter(t *testing.T) {
+	var tests = []struct {
+		filter    string
+		container string
+		want      bool
+	}{
+		{"", "istio-proxy", true},
+		{"app", "app", true},
+		{"app", "app-sidecar", false},
+		{"app,worker-*", "worker-1", true},
+		{"!istio-proxy", "app", true},
+		{"!istio-proxy", "istio-proxy", false},
+		{"!istio-*,!linkerd-proxy", "linkerd-proxy", false},
+		{"app*,!app-debug", "app-debug", false},
+		{"app*,!app-debug", "app-main", true},
+	}
+	for _, tt := range tests {
+		f, err := NewContainerFilter(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := f.Match(tt.container)
+		if got != tt.want {
+			t.Errorf("%s %s\ngot %v\nwant %v", tt.filter, tt.container, got, tt.want)
+		}
+	}
+	if _, err := NewContainerFilter("app[,"); err == nil {
+		t.Error("want error")
+	}
+}
@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k1LoW/harvest/client/k8s"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// K8sEventsClient reads Kubernetes Events as logs
+type K8sEventsClient struct {
+	contextName string
+	namespace   string
+	clientset   kubernetes.Interface
+	lineChan    chan Line
+	logger      *zap.Logger
+}
+
+// NewK8sEventsClient returns K8sEventsClient. If the namespace of path is empty, events of all namespaces are read.
+func NewK8sEventsClient(l *zap.Logger, host, path string) (Client, error) {
+	ns := strings.Trim(path, "/")
+	if strings.Contains(ns, "/") {
+		return nil, fmt.Errorf("invalid k8s-events source path: %s", path)
+	}
+	clientset, err := k8s.NewKubeClientSet(host)
+	if err != nil {
+		return nil, err
+	}
+	return &K8sEventsClient{
+		contextName: host,
+		namespace:   ns,
+		clientset:   clientset,
+		lineChan:    make(chan Line),
+		logger:      l,
+	}, nil
+}
+
+// Read ...
+func (c *K8sEventsClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	events, _, err := c.list(st, et)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		select {
+		case <-ctx.Done():
+			return nil
+		case c.lineChan <- c.eventToLine(e, et):
+		}
+	}
+	return nil
+}
+
+// Tailf watches events
+func (c *K8sEventsClient) Tailf(ctx context.Context) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	_, resourceVersion, err := c.list(nil, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		watcher, err := c.clientset.CoreV1().Events(c.namespace).Watch(metav1.ListOptions{ResourceVersion: resourceVersion})
+		if err != nil {
+			return err
+		}
+		var gone bool
+		resourceVersion, gone, err = c.watch(ctx, watcher, resourceVersion)
+		watcher.Stop()
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if gone {
+			// the resource version is too old, so restart watching from now
+			_, resourceVersion, err = c.list(nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Ls ...
+func (c *K8sEventsClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	p := fmt.Sprintf("/%s", c.namespace)
+	c.lineChan <- Line{
+		Host:     c.contextName,
+		Path:     p,
+		Content:  fmt.Sprintf("%s Events", p),
+		TimeZone: "",
+	}
+	return nil
+}
+
+// Copy writes events between st and et to <dstDir>/<context>/<namespace>/events.log
+func (c *K8sEventsClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	dstLogFilePath := filepath.Join(dstDir, c.contextName, c.namespace, "events.log")
+	err := os.MkdirAll(filepath.Dir(dstLogFilePath), 0755) // #nosec
+	if err != nil {
+		return err
+	}
+	events, _, err := c.list(st, et)
+	if err != nil {
+		return err
+	}
+	dst, err := os.Create(dstLogFilePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	for _, e := range events {
+		_, err := fmt.Fprintf(dst, "%s %s\n", eventTimestampUntil(e, et).Format(time.RFC3339Nano), eventContent(e))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RandomOne reads the latest event
+func (c *K8sEventsClient) RandomOne(ctx context.Context) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	events, _, err := c.list(nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	c.lineChan <- c.eventToLine(events[len(events)-1], nil)
+	return nil
+}
+
+// Out ...
+func (c *K8sEventsClient) Out() <-chan Line {
+	return c.lineChan
+}
+
+// list returns events occurred between st and et ( the range from the first timestamp to the last timestamp overlaps st - et ) in order of timestamp, and the resource version of the list
+func (c *K8sEventsClient) list(st, et *time.Time) ([]corev1.Event, string, error) {
+	list, err := c.clientset.CoreV1().Events(c.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	events := []corev1.Event{}
+	for _, e := range list.Items {
+		if st != nil && eventTimestamp(e).Before(*st) {
+			continue
+		}
+		if et != nil && eventFirstTimestamp(e).After(*et) {
+			continue
+		}
+		events = append(events, e)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTimestampUntil(events[i], et).Before(eventTimestampUntil(events[j], et))
+	})
+	return events, list.ResourceVersion, nil
+}
+
+// watch sends events to c.lineChan until the watcher is closed, and returns the last resource version ( gone is true if the resource version is too old )
+func (c *K8sEventsClient) watch(ctx context.Context, watcher watch.Interface, resourceVersion string) (string, bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion, false, nil
+		case we, ok := <-watcher.ResultChan():
+			if !ok {
+				return resourceVersion, false, nil
+			}
+			switch we.Type {
+			case watch.Added, watch.Modified:
+				e, ok := we.Object.(*corev1.Event)
+				if !ok {
+					continue
+				}
+				resourceVersion = e.ResourceVersion
+				select {
+				case <-ctx.Done():
+					return resourceVersion, false, nil
+				case c.lineChan <- c.eventToLine(*e, nil):
+				}
+			case watch.Error:
+				status, ok := we.Object.(*metav1.Status)
+				if ok && status.Code == http.StatusGone {
+					return resourceVersion, true, nil
+				}
+				return resourceVersion, false, fmt.Errorf("failed to watch events: %v", we.Object)
+			}
+		}
+	}
+}
+
+// eventToLine returns the line of the event with the timestamp until et
+func (c *K8sEventsClient) eventToLine(e corev1.Event, et *time.Time) Line {
+	ts := eventTimestampUntil(e, et)
+	fields := map[string]string{}
+	for k, v := range map[string]string{
+		"type":      e.Type,
+		"reason":    e.Reason,
+		"kind":      e.InvolvedObject.Kind,
+		"name":      e.InvolvedObject.Name,
+		"component": e.Source.Component,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	if e.Count > 1 {
+		fields["count"] = strconv.Itoa(int(e.Count))
+	}
+	return Line{
+		Host:               c.contextName,
+		Path:               strings.Join([]string{"", e.Namespace, e.InvolvedObject.Kind, e.InvolvedObject.Name}, "/"),
+		Content:            eventContent(e),
+		TimeZone:           "",
+		TimestampViaClient: &ts,
+		Fields:             fields,
+	}
+}
+
+// eventTimestamp returns the time when the event occurred most recently
+func eventTimestamp(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	case !e.FirstTimestamp.IsZero():
+		return e.FirstTimestamp.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
+
+// eventFirstTimestamp returns the time when the event occurred first
+func eventFirstTimestamp(e corev1.Event) time.Time {
+	switch {
+	case !e.FirstTimestamp.IsZero():
+		return e.FirstTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return eventTimestamp(e)
+	}
+}
+
+// eventTimestampUntil returns the time when the event occurred most recently until et ( events occurring again after et are at et )
+func eventTimestampUntil(e corev1.Event, et *time.Time) time.Time {
+	ts := eventTimestamp(e)
+	if et != nil && ts.After(*et) {
+		return *et
+	}
+	return ts
+}
+
+// eventContent renders the event like `kubectl get events` ( e.g. Warning BackOff Pod/api-0: Back-off restarting failed container (x5) )
+func eventContent(e corev1.Event) string {
+	content := fmt.Sprintf("%s %s %s/%s: %s", e.Type, e.Reason, e.InvolvedObject.Kind, e.InvolvedObject.Name, strings.TrimSpace(e.Message))
+	if e.Count > 1 {
+		content = fmt.Sprintf("%s (x%d)", content, e.Count)
+	}
+	return content
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	promptMu    sync.Mutex
+	promptCache = map[string][]byte{}
+)
+
+// PromptCredential asks the credential ( passphrase or password ) on the terminal.
+// The answer is cached by key, and prompts of concurrent connections are asked one by one.
+// The terminal ( /dev/tty ) is used instead of stdin, so that logs can be read from stdin.
+func PromptCredential(key, msg string) ([]byte, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	if v, ok := promptCache[key]; ok {
+		return v, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("no terminal to prompt: %s", msg))
+	}
+	defer tty.Close()
+	_, _ = fmt.Fprintf(tty, "%s: ", msg)
+	v, err := terminal.ReadPassword(int(tty.Fd()))
+	_, _ = fmt.Fprintln(tty)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	promptCache[key] = v
+	return v, nil
+}
+
+// PassphraseKey returns the key of PromptCredential for the passphrase of the identity file
+func PassphraseKey(keyPath string) string {
+	return fmt.Sprintf("passphrase:%s", keyPath)
+}
+
+// PasswordKey returns the key of PromptCredential for the password of the destination ( the result of SSHDest )
+func PasswordKey(dest string) string {
+	return fmt.Sprintf("password:%s", dest)
+}
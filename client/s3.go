@@ -0,0 +1,409 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	s3DefaultRegion = "us-east-1"
+	s3EmptyHash     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3TimeFormat    = "20060102T150405Z"
+)
+
+// S3Client reads logs archived in S3 compatible object storage
+type S3Client struct {
+	bucket    string
+	path      string
+	endpoint  string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	http      *http.Client
+	lineChan  chan Line
+	logger    *zap.Logger
+}
+
+// S3Option ...
+type S3Option func(*S3Client) error
+
+// S3Endpoint set the endpoint of the object storage ( e.g. http://localhost:9000 for MinIO )
+func S3Endpoint(endpoint string) S3Option {
+	return func(c *S3Client) error {
+		if endpoint == "" {
+			return nil
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid s3 endpoint: %s", endpoint)
+		}
+		c.endpoint = strings.TrimSuffix(endpoint, "/")
+		return nil
+	}
+}
+
+// S3Region set the region of the bucket
+func S3Region(region string) S3Option {
+	return func(c *S3Client) error {
+		if region != "" {
+			c.region = region
+		}
+		return nil
+	}
+}
+
+// s3Object ...
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// s3ListBucketResult is the response of ListObjectsV2
+type s3ListBucketResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// s3Error ...
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// NewS3Client returns S3Client.
+// Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN ( requests are not signed if they are not set ).
+func NewS3Client(l *zap.Logger, bucket, path string, opts ...S3Option) (Client, error) {
+	if bucket == "" || bucket == "localhost" {
+		return nil, fmt.Errorf("invalid s3 bucket: %s", bucket)
+	}
+	c := &S3Client{
+		bucket:    bucket,
+		path:      path,
+		region:    s3DefaultRegion,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		http:      &http.Client{},
+		lineChan:  make(chan Line),
+		logger:    l,
+	}
+	for _, env := range []string{"AWS_DEFAULT_REGION", "AWS_REGION"} {
+		if r := os.Getenv(env); r != "" {
+			c.region = r
+		}
+	}
+	if err := S3Endpoint(os.Getenv("AWS_ENDPOINT_URL"))(c); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Read ...
+func (c *S3Client) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	objects, err := c.find(ctx, st)
+	if err != nil {
+		return err
+	}
+	return bindWriterFuncAndChan(ctx, c.logger, c.lineChan, c.bucket, c.path, "", func(w io.Writer) error {
+		for _, o := range objects {
+			err := c.cat(ctx, w, o.Key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Tailf ...
+func (c *S3Client) Tailf(ctx context.Context) error {
+	return fmt.Errorf("not supported: stream s3 sources: s3://%s%s", c.bucket, c.path)
+}
+
+// Ls ...
+func (c *S3Client) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	objects, err := c.find(ctx, st)
+	if err != nil {
+		return err
+	}
+	return bindWriterFuncAndChan(ctx, c.logger, c.lineChan, c.bucket, c.path, "", func(w io.Writer) error {
+		for _, o := range objects {
+			_, err := fmt.Fprintf(w, "/%s\n", o.Key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Copy downloads the raw object
+func (c *S3Client) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	dstLogFilePath := filepath.Join(dstDir, c.bucket, filePath)
+	dstLogDir := filepath.Dir(dstLogFilePath)
+	err := os.MkdirAll(dstLogDir, 0755) // #nosec
+	if err != nil {
+		return err
+	}
+	body, err := c.get(ctx, strings.TrimPrefix(filePath, "/"))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	dst, err := os.Create(dstLogFilePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, body)
+	return err
+}
+
+// RandomOne ...
+func (c *S3Client) RandomOne(ctx context.Context) error {
+	objects, err := c.find(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return bindWriterFuncAndChan(ctx, c.logger, c.lineChan, c.bucket, c.path, "", func(w io.Writer) error {
+		return readRandomLine(w, len(objects), func(i int) (io.ReadCloser, error) {
+			return c.get(ctx, objects[i].Key)
+		})
+	})
+}
+
+// Out ...
+func (c *S3Client) Out() <-chan Line {
+	return c.lineChan
+}
+
+// find returns objects matching the glob modified after st ( like `find -newermt` ), in order of LastModified
+func (c *S3Client) find(ctx context.Context, st *time.Time) ([]s3Object, error) {
+	pattern := strings.TrimPrefix(c.path, "/")
+	prefix := pattern
+	if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+		prefix = pattern[:i]
+	}
+	objects := []s3Object{}
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		res, err := c.do(ctx, "", q)
+		if err != nil {
+			return nil, err
+		}
+		result := s3ListBucketResult{}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range result.Contents {
+			matched, err := path.Match(pattern, o.Key)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+			if st != nil && !o.LastModified.After(*st) {
+				continue
+			}
+			objects = append(objects, o)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].LastModified.Before(objects[j].LastModified)
+	})
+	return objects, nil
+}
+
+// cat writes the decompressed object to w
+func (c *S3Client) cat(ctx context.Context, w io.Writer, key string) error {
+	body, err := c.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	r, err := newDecompressReader(body)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (c *S3Client) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := c.do(ctx, key, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// do sends the GET request for the key ( or the bucket if key is empty ) signed with AWS Signature Version 4
+func (c *S3Client) do(ctx context.Context, key string, q url.Values) (*http.Response, error) {
+	var u string
+	if c.endpoint != "" {
+		// path-style for S3 compatible storages
+		u = fmt.Sprintf("%s/%s", c.endpoint, s3Escape(c.bucket, false))
+		if key != "" {
+			u = fmt.Sprintf("%s/%s", u, s3Escape(key, false))
+		}
+	} else {
+		u = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", c.bucket, c.region, s3Escape(key, false))
+	}
+	if qs := s3CanonicalQuery(q); qs != "" {
+		u = fmt.Sprintf("%s?%s", u, qs)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.accessKey != "" {
+		c.sign(req, time.Now())
+	}
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		e := s3Error{}
+		b, _ := ioutil.ReadAll(res.Body)
+		if xml.Unmarshal(b, &e) != nil || e.Code == "" {
+			e.Code = res.Status
+		}
+		return nil, fmt.Errorf("S3 API error (%s/%s): %s: %s", c.bucket, key, e.Code, e.Message)
+	}
+	return res, nil
+}
+
+// sign signs the request with AWS Signature Version 4
+func (c *S3Client) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format(s3TimeFormat)
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", s3EmptyHash)
+	if c.token != "" {
+		req.Header.Set("x-amz-security-token", c.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "range" {
+			headers[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	names := []string{}
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += fmt.Sprintf("%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, c.region)
+	h := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(h[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + c.secretKey)
+	for _, s := range []string{date, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	_, _ = m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+// s3CanonicalQuery returns the query string sorted by key and escaped as AWS Signature Version 4 requires
+func s3CanonicalQuery(q url.Values) string {
+	keys := []string{}
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := []string{}
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			params = append(params, fmt.Sprintf("%s=%s", s3Escape(k, true), s3Escape(v, true)))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3Escape escapes s except unreserved characters ( and '/' if encodeSlash is false )
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ('A' <= ch && ch <= 'Z') || ('a' <= ch && ch <= 'z') || ('0' <= ch && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || (ch == '/' && !encodeSlash) {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// seekMinSize is the minimum size of the uncompressed file to seek by timestamp
+	seekMinSize = 64 * 1024 * 1024
+	// seekProbeSize is the bytes read at each probe of the binary search
+	seekProbeSize = 64 * 1024
+)
+
+// TimestampFunc returns the timestamp of the line in the time zone of the host ( nil if the line has no timestamp )
+type TimestampFunc func(content, tz string) *time.Time
+
+// probeFunc returns the bytes of the file from off ( at most n bytes )
+type probeFunc func(off, n int64) ([]byte, error)
+
+// seekRange returns the byte range [start, end) of the file containing the records between st and et.
+// Timestamps of the records in the file must increase monotonically.
+func seekRange(size int64, probe probeFunc, ts TimestampFunc, tz string, st, et *time.Time) (int64, int64, error) {
+	start := int64(0)
+	end := size
+	if st != nil {
+		lo, _, err := seekOffset(start, end, probe, ts, tz, *st, false)
+		if err != nil {
+			return 0, 0, err
+		}
+		start = lo
+	}
+	if et != nil {
+		_, hi, err := seekOffset(start, end, probe, ts, tz, *et, true)
+		if err != nil {
+			return 0, 0, err
+		}
+		end = hi
+	}
+	return start, end, nil
+}
+
+// seekOffset binary-searches the heads of records around t between lo and hi.
+// The records before the returned lo have timestamps < t, and the records from the returned hi have timestamps >= t.
+// If after is true, records with timestamps equal to t are regarded as before t ( <= t and > t ).
+// If a record is longer than seekProbeSize, the search stops there ( the range gets wider, but records are not cut ).
+func seekOffset(lo, hi int64, probe probeFunc, ts TimestampFunc, tz string, t time.Time, after bool) (int64, int64, error) {
+	for hi-lo > seekProbeSize {
+		mid := lo + (hi-lo)/2
+		b, err := probe(mid, seekProbeSize)
+		if err != nil {
+			return 0, 0, err
+		}
+		// skip the line containing mid, because it may be read from the middle
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+		pos := mid + int64(i) + 1
+		b = b[i+1:]
+		found := false
+		for pos < hi {
+			j := bytes.IndexByte(b, '\n')
+			if j < 0 {
+				// the last line may be incomplete
+				break
+			}
+			if lts := ts(strings.TrimSuffix(string(b[:j]), "\r"), tz); lts != nil {
+				found = true
+				before := lts.UnixNano() < t.UnixNano()
+				if after {
+					before = lts.UnixNano() <= t.UnixNano()
+				}
+				if !before {
+					hi = pos
+					break
+				}
+				lo = pos
+			}
+			pos += int64(j) + 1
+			b = b[j+1:]
+		}
+		if !found {
+			break
+		}
+	}
+	return lo, hi, nil
+}
+
+// seekHeadSize is the bytes at the head of files listed by buildSeekListCommand ( the magic bytes and the identity of the file with the inode )
+const seekHeadSize = 32
+
+// FileOffset is the byte offset of the file to resume reading from.
+// The file is identified by the inode and the bytes at the head of the file, so that it is resumed after it is renamed by the rotation.
+type FileOffset struct {
+	Path   string
+	Inode  string
+	Head   string
+	Offset int64
+}
+
+// resumer resumes reading files from the offsets of the last read, and records the offsets to resume from next time
+type resumer struct {
+	ts      TimestampFunc
+	offsets map[string]FileOffset
+	next    []FileOffset
+}
+
+func newResumer(offsets []FileOffset, ts TimestampFunc) *resumer {
+	r := &resumer{
+		ts:      ts,
+		offsets: map[string]FileOffset{},
+	}
+	for _, o := range offsets {
+		r.offsets[o.Inode] = o
+	}
+	return r
+}
+
+// offset returns the offset of the file to resume reading from ( 0 if the file is not read before, or truncated )
+func (r *resumer) offset(inode, head string, size int64) int64 {
+	o, ok := r.offsets[inode]
+	if !ok || o.Offset > size || !strings.HasPrefix(head, o.Head) {
+		return 0
+	}
+	return o.Offset
+}
+
+// buildSeekListCommand returns the command listing files to read with the head bytes, the size and the inode ( `head<TAB>size<TAB>inode<TAB>path` )
+func buildSeekListCommand(path string, st *time.Time) string {
+	dir := filepath.Dir(path)
+	base := filepath.Base(path)
+
+	findStart := st.Format("2006-01-02 15:04:05 MST")
+
+	cmd := fmt.Sprintf(`find %s/ -type f -name '%s' -newermt '%s' | xargs ls -tr | while IFS= read -r f; do printf '%%s\t%%s\t%%s\t%%s\n' "$(head -c %d "$f" | od -An -tx1 | tr -d ' \n')" "$(wc -c < "$f" | tr -d ' ')" "$(ls -di "$f" | awk '{print $1}')" "$f" || exit; done`, dir, base, findStart, seekHeadSize)
+
+	return pipefail + cmd
+}
+
+// buildProbeCommand returns the command printing n bytes of the file from off
+func buildProbeCommand(path string, off, n int64) string {
+	return fmt.Sprintf("tail -c +%d %s | head -c %d", off+1, shellQuote(path), n)
+}
+
+// buildSeekReadCommand is the version of buildReadCommand that reads only the byte ranges of large uncompressed files between st and et ( if ts is not nil ),
+// and resumes reading uncompressed files from the offsets of the last read ( if r is not nil ).
+// The ranges are found by the binary search using probe commands run by output.
+func buildSeekReadCommand(ctx context.Context, output func(ctx context.Context, cmd string) ([]byte, error), path string, st, et *time.Time, tz string, tf *timeFilter, ts TimestampFunc, r *resumer) (string, error) {
+	out, err := output(ctx, buildSeekListCommand(path, st))
+	if err != nil {
+		return "", err
+	}
+	cmds := []string{}
+	next := []FileOffset{}
+	done := false
+	for _, l := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if l == "" {
+			continue
+		}
+		fields := strings.SplitN(l, "\t", 4)
+		if len(fields) != 4 {
+			return "", fmt.Errorf("invalid file list: %s", l)
+		}
+		head, _ := hex.DecodeString(fields[0])
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return "", err
+		}
+		inode := fields[2]
+		f := fields[3]
+		if compression(head) != "" {
+			if !done {
+				cmds = append(cmds, fmt.Sprintf("printf '%%s\\n' %s | %s", shellQuote(f), decompressCommand))
+			}
+			continue
+		}
+		probe := func(off, n int64) ([]byte, error) {
+			return output(ctx, buildProbeCommand(f, off, n))
+		}
+		start := int64(0)
+		if r != nil {
+			start = r.offset(inode, fields[0], size)
+		}
+		if done {
+			// the file is not read, so the offset of the last read is kept
+			if start > 0 {
+				next = append(next, FileOffset{Path: f, Inode: inode, Head: fields[0], Offset: start})
+			}
+			continue
+		}
+		end := size
+		switch {
+		case start > 0:
+			// the records before the offset have been read by the last read
+		case ts != nil && size >= seekMinSize:
+			start, end, err = seekRange(size, probe, ts, tz, st, et)
+			if err != nil {
+				return "", err
+			}
+		}
+		switch {
+		case start == 0 && end >= size:
+			cmds = append(cmds, fmt.Sprintf("printf '%%s\\n' %s | %s", shellQuote(f), decompressCommand))
+		case end >= size:
+			// the file may be growing, so read until the end
+			cmds = append(cmds, fmt.Sprintf("tail -c +%d %s", start+1, shellQuote(f)))
+		case end > start:
+			// tail is killed by SIGPIPE when head exits
+			cmds = append(cmds, fmt.Sprintf("{ %s || test $? -eq 141; }", buildProbeCommand(f, start, end-start)))
+		}
+		if r != nil {
+			off := end
+			if end >= size {
+				off, err = resumeOffset(start, size, probe, r.ts, tz, et)
+				if err != nil {
+					return "", err
+				}
+			}
+			next = append(next, FileOffset{Path: f, Inode: inode, Head: fields[0], Offset: off})
+		}
+		if end < size {
+			// the following files have only records after et
+			done = true
+		}
+	}
+	if r != nil {
+		r.next = next
+	}
+	if len(cmds) == 0 {
+		return "true", nil
+	}
+	return fmt.Sprintf("%s{ %s; }%s", pipefail, strings.Join(cmds, " && "), tf.command()), nil
+}
+
+// resumeOffset returns the offset of the file read from start to size, to resume reading from next time.
+// It is the head of the first record after et ( dropped by the time filter ), or the head of the last record ( read again and skipped as duplicates, because it may be continued ).
+// Only the last seekProbeSize bytes are checked, and start is returned if the offset is not found in them.
+func resumeOffset(start, size int64, probe probeFunc, ts TimestampFunc, tz string, et *time.Time) (int64, error) {
+	lo := start
+	if size-lo > seekProbeSize {
+		lo = size - seekProbeSize
+	}
+	b, err := probe(lo, size-lo)
+	if err != nil {
+		return 0, err
+	}
+	pos := lo
+	if lo > start {
+		// skip the line containing lo, because it may be read from the middle
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			return start, nil
+		}
+		pos += int64(i) + 1
+		b = b[i+1:]
+	}
+	last := start
+	found := false
+	for {
+		j := bytes.IndexByte(b, '\n')
+		if j < 0 {
+			// the last line may be incomplete
+			break
+		}
+		if lts := ts(strings.TrimSuffix(string(b[:j]), "\r"), tz); lts != nil {
+			if et != nil && lts.After(*et) {
+				if !found {
+					// the records after et may start before lo
+					return start, nil
+				}
+				return pos, nil
+			}
+			found = true
+			last = pos
+		}
+		pos += int64(j) + 1
+		b = b[j+1:]
+	}
+	return last, nil
+}
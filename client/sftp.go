@@ -0,0 +1,386 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const sftpPollInterval = 1 * time.Second
+
+// sftpFile ...
+type sftpFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// readViaSFTP is the SFTP version of buildReadCommand
+func (c *SSHClient) readViaSFTP(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	files, err := c.findViaSFTP(st)
+	if err != nil {
+		return err
+	}
+	tz, err := c.conn.timeZone(ctx)
+	if err != nil && (c.seek != nil || timeZone == "") {
+		return err
+	}
+	if timeZone != "" {
+		tz = timeZone
+	}
+	sel, err := newTimeFilter(st, et, timeFormat, tz, c.head).selector()
+	if err != nil {
+		return err
+	}
+	return c.bindViaSFTP(ctx, func(w io.Writer) error {
+		for _, f := range files {
+			if c.seek != nil && f.size >= seekMinSize && !c.compressedViaSFTP(f.path) {
+				end, err := c.catRangeViaSFTP(w, f, sel, st, et, tz)
+				if err != nil {
+					return err
+				}
+				if end < f.size {
+					// the following files have only records after et
+					return nil
+				}
+				continue
+			}
+			err := c.catViaSFTP(w, f.path, sel)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tailfViaSFTP is the SFTP version of buildTailfCommand
+func (c *SSHClient) tailfViaSFTP(ctx context.Context) error {
+	in := make(chan Line)
+	done := make(chan struct{})
+	go func() {
+		bindTailfLinesAndChan(in, c.lineChan)
+		close(done)
+	}()
+	err := c.bindViaSFTPAndChan(ctx, in, func(w io.Writer) error {
+		return c.followViaSFTP(ctx, w)
+	})
+	<-done
+	return err
+}
+
+// lsViaSFTP is the SFTP version of buildLsCommand
+func (c *SSHClient) lsViaSFTP(ctx context.Context, st *time.Time) error {
+	files, err := c.findViaSFTP(st)
+	if err != nil {
+		return err
+	}
+	return c.bindViaSFTP(ctx, func(w io.Writer) error {
+		for _, f := range files {
+			_, err := fmt.Fprintln(w, f.path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// randomOneViaSFTP is the SFTP version of buildRandomOneCommand
+func (c *SSHClient) randomOneViaSFTP(ctx context.Context) error {
+	files, err := c.findViaSFTP(nil)
+	if err != nil {
+		return err
+	}
+	return c.bindViaSFTP(ctx, func(w io.Writer) error {
+		return readRandomLine(w, len(files), func(i int) (io.ReadCloser, error) {
+			return c.sftp.Open(files[i].path)
+		})
+	})
+}
+
+// copyViaSFTP ...
+func (c *SSHClient) copyViaSFTP(ctx context.Context, filePath string, dstLogFilePath string) error {
+	src, err := c.sftp.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstLogFilePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// bindViaSFTP pipes the output of fn to c.lineChan
+func (c *SSHClient) bindViaSFTP(ctx context.Context, fn func(w io.Writer) error) error {
+	return c.bindViaSFTPAndChan(ctx, c.lineChan, fn)
+}
+
+// bindViaSFTPAndChan pipes the output of fn to lineChan
+func (c *SSHClient) bindViaSFTPAndChan(ctx context.Context, lineChan chan Line, fn func(w io.Writer) error) error {
+	c.logger.Debug("Start reading via SFTP")
+	tz, err := c.conn.timeZone(ctx)
+	if err != nil {
+		c.logger.Debug("Failed to get time zone of remote host", zap.Error(err))
+		tz = ""
+	}
+	err = bindWriterFuncAndChan(ctx, c.logger, lineChan, c.host, c.path, tz, fn)
+	c.logger.Debug("Finish reading via SFTP")
+	return err
+}
+
+// findViaSFTP returns files matching c.path modified after st, in order of modification time
+func (c *SSHClient) findViaSFTP(st *time.Time) ([]sftpFile, error) {
+	dir := path.Dir(c.path)
+	base := path.Base(c.path)
+
+	files := []sftpFile{}
+	walker := c.sftp.Walk(dir)
+	for walker.Step() {
+		if walker.Err() != nil {
+			c.logger.Debug("Walk error", zap.Error(walker.Err()))
+			continue
+		}
+		fi := walker.Stat()
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		matched, err := path.Match(base, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		if st != nil && !fi.ModTime().After(*st) {
+			continue
+		}
+		files = append(files, sftpFile{
+			path:    walker.Path(),
+			size:    fi.Size(),
+			modTime: fi.ModTime(),
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, nil
+}
+
+// catViaSFTP writes lines of the file selected by sel to w
+func (c *SSHClient) catViaSFTP(w io.Writer, filePath string, sel func(line []byte) bool) error {
+	f, err := c.sftp.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := newDecompressReader(f)
+	if err != nil {
+		return err
+	}
+	return writeSelectedLines(w, r, sel)
+}
+
+// catRangeViaSFTP writes lines of the uncompressed file between st and et selected by sel to w, and returns the end offset of the range.
+// The range is found by the binary search using ReadAt ( see Seek ).
+func (c *SSHClient) catRangeViaSFTP(w io.Writer, sf sftpFile, sel func(line []byte) bool, st, et *time.Time, tz string) (int64, error) {
+	f, err := c.sftp.Open(sf.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	probe := func(off, n int64) ([]byte, error) {
+		b := make([]byte, n)
+		m, err := f.ReadAt(b, off)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return b[:m], nil
+	}
+	start, end, err := seekRange(sf.size, probe, c.seek, tz, st, et)
+	if err != nil {
+		return 0, err
+	}
+	r := io.Reader(io.NewSectionReader(f, start, end-start))
+	if end >= sf.size {
+		// the file may be growing, so read until the end
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r = f
+	}
+	return end, writeSelectedLines(w, r, sel)
+}
+
+// writeSelectedLines writes lines of r selected by sel to w
+func writeSelectedLines(w io.Writer, r io.Reader, sel func(line []byte) bool) error {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, initialScanTokenSize)
+	scanner.Buffer(buf, maxScanTokenSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !sel(line) {
+			continue
+		}
+		_, err := w.Write(line)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write([]byte("\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// sftpFollowedFile is the file followed via SFTP
+type sftpFollowedFile struct {
+	sftpFile
+	offset     int64
+	incomplete []byte
+	ignored    bool
+}
+
+// followViaSFTP polls the files matching c.path and writes appended lines to w in the same format as buildTailfCommand
+func (c *SSHClient) followViaSFTP(ctx context.Context, w io.Writer) error {
+	files, err := c.findViaSFTP(nil)
+	if err != nil {
+		return err
+	}
+	followed := map[string]*sftpFollowedFile{}
+	for _, f := range files {
+		followed[f.path] = &sftpFollowedFile{sftpFile: f, offset: f.size, ignored: c.compressedViaSFTP(f.path)}
+	}
+
+	ticker := time.NewTicker(sftpPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		files, err := c.findViaSFTP(nil)
+		if err != nil {
+			return err
+		}
+		found := map[string]sftpFile{}
+		for _, f := range files {
+			found[f.path] = f
+		}
+		current := map[string]*sftpFollowedFile{}
+		renamed := map[string]struct{}{}
+		for _, f := range files {
+			if _, ok := followed[f.path]; ok {
+				continue
+			}
+			for _, ff := range followed {
+				if _, r := renamed[ff.path]; r {
+					continue
+				}
+				if o, ok := found[ff.path]; ok && o.size >= ff.size && !o.modTime.Before(ff.modTime) {
+					// still there
+					continue
+				}
+				// SFTP does not provide the inode, so the file having the same size and modification time is regarded as renamed ( rotated )
+				if ff.size == f.size && ff.modTime.Equal(f.modTime) {
+					current[f.path] = &sftpFollowedFile{sftpFile: f, offset: ff.offset, incomplete: ff.incomplete, ignored: ff.ignored}
+					renamed[ff.path] = struct{}{}
+					break
+				}
+			}
+		}
+		for _, f := range files {
+			ff, ok := current[f.path]
+			if !ok {
+				ff, ok = followed[f.path]
+				if _, r := renamed[f.path]; !ok || r {
+					// files found after starting are read from the beginning
+					ff = &sftpFollowedFile{sftpFile: f, ignored: c.compressedViaSFTP(f.path)}
+				}
+				current[f.path] = ff
+			}
+			ff.sftpFile = f
+			if ff.ignored {
+				continue
+			}
+			if f.size < ff.offset {
+				// truncated
+				ff.offset = 0
+				ff.incomplete = nil
+			}
+			if f.size == ff.offset {
+				continue
+			}
+			err := c.writeFollowedLinesViaSFTP(w, ff)
+			if err != nil {
+				return err
+			}
+		}
+		followed = current
+	}
+}
+
+// writeFollowedLinesViaSFTP writes lines appended to the file prefixed with the file path
+func (c *SSHClient) writeFollowedLinesViaSFTP(w io.Writer, ff *sftpFollowedFile) error {
+	f, err := c.sftp.Open(ff.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = f.Seek(ff.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(f)
+	ff.offset += int64(len(b))
+	if err != nil {
+		return err
+	}
+	b = append(ff.incomplete, b...)
+	i := bytes.LastIndexByte(b, '\n')
+	ff.incomplete = append([]byte{}, b[i+1:]...)
+	if i < 0 {
+		return nil
+	}
+	for _, line := range bytes.Split(b[:i], []byte("\n")) {
+		_, err := fmt.Fprintf(w, "%s\t%s\n", ff.path, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressedViaSFTP reports whether the file is compressed ( by the extension or the magic bytes )
+func (c *SSHClient) compressedViaSFTP(filePath string) bool {
+	switch path.Ext(filePath) {
+	case ".gz", ".xz", ".bz2", ".zst":
+		return true
+	}
+	f, err := c.sftp.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 6)
+	n, _ := io.ReadFull(f, magic)
+	return compression(magic[:n]) != ""
+}
@@ -1,83 +1,338 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/k1LoW/sshc"
+	"github.com/pkg/sftp"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
 )
 
 // SSHClient ...
 type SSHClient struct {
-	host     string
-	path     string
-	client   *ssh.Client
-	lineChan chan Line
-	logger   *zap.Logger
+	// bytes read from the SSH channel and bytes after decompression ( accessed atomically, so kept at the top for 64-bit alignment )
+	wireBytes    int64
+	decodedBytes int64
+
+	host        string
+	path        string
+	conn        *sshConn
+	sftp        *sftp.Client
+	useSFTP     bool
+	jumpHosts   []string
+	pool        *SSHPool
+	maxSessions int
+	dialCtx     context.Context
+	become      *Become
+	filter      *Filter
+	compression string
+	auth        SSHAuth
+	seek        TimestampFunc
+	resume      *resumer
+	head        string
+	lineChan    chan Line
+	logger      *zap.Logger
+}
+
+// SSHOption ...
+type SSHOption func(*SSHClient) error
+
+// UseSFTP read logs through the SFTP subsystem instead of remote commands
+func UseSFTP(u bool) SSHOption {
+	return func(c *SSHClient) error {
+		c.useSFTP = u
+		return nil
+	}
+}
+
+// JumpHosts connect to the host through jump hosts ( like ProxyJump of ssh_config )
+func JumpHosts(hosts []string) SSHOption {
+	return func(c *SSHClient) error {
+		for _, h := range hosts {
+			if _, err := parseSSHDest(h); err != nil {
+				return err
+			}
+		}
+		c.jumpHosts = hosts
+		return nil
+	}
+}
+
+// UseSSHPool share the SSH connection with other SSHClients using the pool
+func UseSSHPool(p *SSHPool) SSHOption {
+	return func(c *SSHClient) error {
+		c.pool = p
+		return nil
+	}
+}
+
+// MaxSessions limit the number of sessions opened at the same time on the shared SSH connection
+func MaxSessions(n int) SSHOption {
+	return func(c *SSHClient) error {
+		c.maxSessions = n
+		return nil
+	}
+}
+
+// DialContext give up connecting to the host ( including waiting for the shared SSH connection dialed by other SSHClients ) when ctx is done
+func DialContext(ctx context.Context) SSHOption {
+	return func(c *SSHClient) error {
+		c.dialCtx = ctx
+		return nil
+	}
+}
+
+// BecomeAs run commands with the privilege escalation
+func BecomeAs(b *Become) SSHOption {
+	return func(c *SSHClient) error {
+		c.become = b
+		return nil
+	}
+}
+
+// ReadFilter filter lines by the remote command on reading logs ( only for single-line logs )
+func ReadFilter(f *Filter) SSHOption {
+	return func(c *SSHClient) error {
+		c.filter = f
+		return nil
+	}
+}
+
+// Compression compress the output of the remote read command with gzip or zstd, and decompress it on reading ( "" or "none" for no compression )
+func Compression(method string) SSHOption {
+	return func(c *SSHClient) error {
+		switch method {
+		case "", "none":
+			c.compression = ""
+		case "gzip", "zstd":
+			c.compression = method
+		default:
+			return fmt.Errorf("unsupport compression: %s", method)
+		}
+		return nil
+	}
+}
+
+// Auth set the settings of SSH authentication and host key verification
+func Auth(a SSHAuth) SSHOption {
+	return func(c *SSHClient) error {
+		if err := a.validate(); err != nil {
+			return err
+		}
+		c.auth = a
+		return nil
+	}
+}
+
+// Seek read only the byte ranges of large uncompressed files between the start time and the end time, found by the binary search with the timestamps of lines
+func Seek(ts TimestampFunc) SSHOption {
+	return func(c *SSHClient) error {
+		c.seek = ts
+		return nil
+	}
+}
+
+// Resume resume reading uncompressed files from the offsets of the last read, and record the offsets to resume from next time.
+// The offset of a file is the head of the first record after the end time, or the head of the last record found by the timestamps of lines.
+func Resume(offsets []FileOffset, ts TimestampFunc) SSHOption {
+	return func(c *SSHClient) error {
+		c.resume = newResumer(offsets, ts)
+		return nil
+	}
+}
+
+// RecordHead set the regexp of the first lines of multi-line records, so that continuation lines are kept with the record on reading logs
+func RecordHead(re string) SSHOption {
+	return func(c *SSHClient) error {
+		c.head = re
+		return nil
+	}
 }
 
 // NewSSHClient ...
-func NewSSHClient(l *zap.Logger, host string, user string, port int, path string, passphrase []byte) (Client, error) {
-	options := []sshc.Option{}
-	if user != "" {
-		options = append(options, sshc.User(user))
+func NewSSHClient(l *zap.Logger, host string, user string, port int, path string, passphrase []byte, opts ...SSHOption) (Client, error) {
+	c := &SSHClient{
+		host:        host,
+		path:        path,
+		maxSessions: DefaultSSHMaxSessions,
+		dialCtx:     context.Background(),
+		become:      &Become{method: BecomeSudo},
+		lineChan:    make(chan Line),
+		logger:      l,
 	}
-	if port > 0 {
-		options = append(options, sshc.Port(port))
+	for _, opt := range opts {
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
 	}
-	options = append(options, sshc.Passphrase(passphrase))
 
-	client, err := sshc.NewClient(host, options...)
-	if err != nil {
-		return nil, err
+	dest := sshDest{host: host, user: user, port: port}
+	if c.pool != nil {
+		conn, err := c.pool.get(c.dialCtx, dest, c.jumpHosts, passphrase, c.auth, c.maxSessions)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	} else {
+		client, err := dialSSH(c.dialCtx, dest, c.jumpHosts, passphrase, c.auth)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = newSSHConn(client, 0)
+	}
+
+	if c.useSFTP {
+		// get time zone before the SFTP subsystem occupies a session
+		_, _ = c.conn.timeZone(c.dialCtx)
+		sc, err := c.conn.sftpClient(c.dialCtx)
+		if err != nil {
+			return nil, err
+		}
+		c.sftp = sc
 	}
-	return &SSHClient{
-		client:   client,
-		host:     host,
-		path:     path,
-		lineChan: make(chan Line),
-		logger:   l,
-	}, nil
+
+	return c, nil
 }
 
 // Read ...
 func (c *SSHClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
-	cmd := buildReadCommand(c.path, st, et, timeFormat, timeZone)
+	if c.useSFTP {
+		return c.readViaSFTP(ctx, st, et, timeFormat, timeZone)
+	}
+	tz, err := c.conn.timeZone(ctx)
+	if err != nil {
+		return err
+	}
+	if timeZone != "" {
+		tz = timeZone
+	}
+	tf := newTimeFilter(st, et, timeFormat, tz, c.head)
+	cmd := buildReadCommand(c.path, st, tf)
+	if c.seek != nil || c.resume != nil {
+		cmd, err = buildSeekReadCommand(ctx, c.output, c.path, st, et, tz, tf, c.seek, c.resume)
+		if err != nil {
+			return err
+		}
+	}
+	cmd = cmd + c.filter.buildGrepCommand()
+	if c.compression != "" {
+		return c.exec(ctx, buildCompressCommand(cmd, c.compression), c.lineChan, true)
+	}
 	return c.Exec(ctx, cmd)
 }
 
+// Transferred returns the bytes read from the SSH channel and the bytes after decompression ( ok is false via SFTP )
+func (c *SSHClient) Transferred() (int64, int64, bool) {
+	if c.useSFTP {
+		return 0, 0, false
+	}
+	return atomic.LoadInt64(&c.wireBytes), atomic.LoadInt64(&c.decodedBytes), true
+}
+
+// Offsets returns the offsets of the files to resume reading from next time ( ok is false without Resume, or via SFTP )
+func (c *SSHClient) Offsets() ([]FileOffset, bool) {
+	if c.resume == nil || c.useSFTP {
+		return nil, false
+	}
+	return c.resume.next, true
+}
+
 // Tailf ...
 func (c *SSHClient) Tailf(ctx context.Context) error {
+	if c.useSFTP {
+		return c.tailfViaSFTP(ctx)
+	}
 	cmd := buildTailfCommand(c.path)
-	return c.Exec(ctx, cmd)
+	in := make(chan Line)
+	done := make(chan struct{})
+	go func() {
+		bindTailfLinesAndChan(in, c.lineChan)
+		close(done)
+	}()
+	err := c.exec(ctx, cmd, in, false)
+	<-done
+	return err
 }
 
 // Ls ...
 func (c *SSHClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	if c.useSFTP {
+		return c.lsViaSFTP(ctx, st)
+	}
 	cmd := buildLsCommand(c.path, st)
 	return c.Exec(ctx, cmd)
 }
 
 // Copy ...
-func (c *SSHClient) Copy(ctx context.Context, filePath string, dstDir string) error {
+func (c *SSHClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
 	dstLogFilePath := filepath.Join(dstDir, c.host, filePath)
 	dstLogDir := filepath.Dir(dstLogFilePath)
 	err := os.MkdirAll(dstLogDir, 0755) // #nosec
 	if err != nil {
 		return err
 	}
-	catCmd := fmt.Sprintf("ssh %s sudo cat %s > %s", c.host, filePath, dstLogFilePath)
-	cmd := exec.CommandContext(ctx, "sh", "-c", catCmd) // #nosec
-	err = cmd.Run()
+	if c.useSFTP {
+		return c.copyViaSFTP(ctx, filePath, dstLogFilePath)
+	}
+	dst, err := os.Create(dstLogFilePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	release, err := c.conn.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	session, err := c.conn.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdout = dst
+	session.Stdin = c.become.stdin()
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return err
+	}
+	stash := newStderrStash(c.logger)
+	stderrDone := make(chan struct{})
+	go func() {
+		stash.bind(stderr)
+		close(stderrDone)
+	}()
+
+	runDone := make(chan struct{})
+	defer close(runDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = session.Close()
+		case <-runDone:
+		}
+	}()
+
+	err = session.Run(c.become.wrap(fmt.Sprintf("cat %s", shellQuote(filePath))))
 	if err != nil {
+		if ee, ok := err.(*ssh.ExitError); ok {
+			<-stderrDone
+			return &ExecError{
+				Host:       c.host,
+				Path:       filePath,
+				ExitStatus: ee.ExitStatus(),
+				Stderr:     stash.String(),
+			}
+		}
 		return err
 	}
 	return nil
@@ -85,6 +340,9 @@ func (c *SSHClient) Copy(ctx context.Context, filePath string, dstDir string) er
 
 // RandomOne ...
 func (c *SSHClient) RandomOne(ctx context.Context) error {
+	if c.useSFTP {
+		return c.randomOneViaSFTP(ctx)
+	}
 	cmd := buildRandomOneCommand(c.path)
 
 	return c.Exec(ctx, cmd)
@@ -92,51 +350,89 @@ func (c *SSHClient) RandomOne(ctx context.Context) error {
 
 // Exec ...
 func (c *SSHClient) Exec(ctx context.Context, cmd string) error {
-	session, err := c.client.NewSession()
+	return c.exec(ctx, cmd, c.lineChan, false)
+}
+
+// exec executes cmd and sends the output lines to lineChan. If compressed is true, the output is decompressed.
+func (c *SSHClient) exec(ctx context.Context, cmd string, lineChan chan Line, compressed bool) error {
+	tz, err := c.conn.timeZone(ctx)
 	if err != nil {
 		return err
 	}
-	c.logger.Debug("Create new SSH session")
-	defer session.Close()
 
-	var tzOut []byte
-	err = func() error {
-		session, err := c.client.NewSession()
-		if err != nil {
-			return err
-		}
-		defer session.Close()
-		tzCmd := `date +"%z"`
-		tzOut, err = session.Output(tzCmd)
-		if err != nil {
-			return err
-		}
-		return nil
-	}()
+	release, err := c.conn.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	session, err := c.conn.client.NewSession()
 	if err != nil {
 		return err
 	}
+	c.logger.Debug("Create new SSH session")
+	defer session.Close()
 
 	stdout, err := session.StdoutPipe()
 	if err != nil {
 		return err
 	}
-	// FIXME
-	// _, err = session.StderrPipe()
-	// if err != nil {
-	// 	return err
-	// }
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return err
+	}
 
-	go bindReaderAndChan(ctx, c.logger, &stdout, c.lineChan, c.host, c.path, strings.TrimRight(string(tzOut), "\n"))
+	session.Stdin = c.become.stdin()
 
-	err = session.Start(cmd)
+	er := newEOFReader(stdout)
+	bindErrChan := make(chan error, 1)
+	go func() {
+		r := io.Reader(&countingReader{r: er, n: &c.wireBytes})
+		if compressed {
+			// the magic bytes can be read only after the session starts
+			dr, err := newDecompressReader(r)
+			if err != nil {
+				close(lineChan)
+				bindErrChan <- err
+				return
+			}
+			if d, ok := dr.(interface{ Close() }); ok {
+				defer d.Close()
+			}
+			r = dr
+		}
+		r = &countingReader{r: r, n: &c.decodedBytes}
+		bindErrChan <- bindReaderAndChan(ctx, c.logger, &r, lineChan, c.host, c.path, tz)
+	}()
+
+	stash := newStderrStash(c.logger)
+	stderrDone := make(chan struct{})
+	go func() {
+		stash.bind(stderr)
+		close(stderrDone)
+	}()
+
+	err = session.Start(c.become.wrap(cmd))
 	if err != nil {
 		return err
 	}
 
+	waitDone := make(chan struct{})
+	defer close(waitDone)
+	canceled := make(chan struct{})
 	go func() {
-		<-ctx.Done()
-		err = session.Close()
+		select {
+		case <-ctx.Done():
+		case err := <-bindErrChan:
+			bindErrChan <- err
+		case <-waitDone:
+			return
+		}
+		if er.reachedEOF() {
+			// all output has been read, so wait for the exit status
+			return
+		}
+		close(canceled)
+		err := session.Close()
 		if err != nil && err != io.EOF {
 			c.logger.Error(fmt.Sprintf("%s", err))
 		}
@@ -144,13 +440,81 @@ func (c *SSHClient) Exec(ctx context.Context, cmd string) error {
 
 	// TODO: use session.Signal()
 	// https://github.com/golang/go/issues/16597
-	_ = session.Wait()
+	err = session.Wait()
 
 	c.logger.Debug("Close SSH session")
 
+	select {
+	case <-canceled:
+		select {
+		case err := <-bindErrChan:
+			return err
+		default:
+			return nil
+		}
+	default:
+	}
+	if err != nil {
+		if ee, ok := err.(*ssh.ExitError); ok {
+			<-stderrDone
+			return &ExecError{
+				Host:       c.host,
+				Path:       c.path,
+				ExitStatus: ee.ExitStatus(),
+				Stderr:     stash.String(),
+			}
+		}
+		return err
+	}
+
 	return nil
 }
 
+// output executes cmd and returns the output
+func (c *SSHClient) output(ctx context.Context, cmd string) ([]byte, error) {
+	release, err := c.conn.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	session, err := c.conn.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	session.Stdin = c.become.stdin()
+	stderr := &bytes.Buffer{}
+	session.Stderr = stderr
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = session.Close()
+		case <-done:
+		}
+	}()
+
+	out, err := session.Output(c.become.wrap(cmd))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if ee, ok := err.(*ssh.ExitError); ok {
+			return nil, &ExecError{
+				Host:       c.host,
+				Path:       c.path,
+				ExitStatus: ee.ExitStatus(),
+				Stderr:     strings.TrimSpace(stderr.String()),
+			}
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
 // Out ...
 func (c *SSHClient) Out() <-chan Line {
 	return c.lineChan
@@ -0,0 +1,593 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/k1LoW/sshc"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const proxyCommandTimeout = 30 * time.Second
+
+// sshDialTimeout bounds the TCP dial and the SSH handshake of each host
+var sshDialTimeout = 30 * time.Second
+
+// sshDest ...
+type sshDest struct {
+	host string
+	user string
+	port int
+}
+
+func (d sshDest) String() string {
+	h := d.host
+	if d.port > 0 {
+		h = net.JoinHostPort(d.host, strconv.Itoa(d.port))
+	}
+	if d.user != "" {
+		h = fmt.Sprintf("%s@%s", d.user, h)
+	}
+	return h
+}
+
+// parseSSHDest parses ProxyJump style destination ( [user@]host[:port] or ssh://[user@]host[:port] )
+func parseSSHDest(s string) (sshDest, error) {
+	if !strings.HasPrefix(s, "ssh://") {
+		s = fmt.Sprintf("ssh://%s", s)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return sshDest{}, err
+	}
+	d := sshDest{
+		host: u.Hostname(),
+		user: u.User.Username(),
+	}
+	if u.Port() != "" {
+		d.port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return sshDest{}, err
+		}
+	}
+	if d.host == "" {
+		return sshDest{}, fmt.Errorf("invalid SSH destination: %s", s)
+	}
+	return d, nil
+}
+
+// Host key policies of SSHAuth
+const (
+	HostKeyPolicyIgnore    = "ignore"
+	HostKeyPolicyStrict    = "strict"
+	HostKeyPolicyAcceptNew = "accept-new"
+)
+
+// SSHAuth is the settings of SSH authentication and host key verification.
+// PasswordAuth is used only for the destination host, and the others are used for jump hosts too.
+type SSHAuth struct {
+	// IdentityFile is used instead of IdentityFile of ssh_config
+	IdentityFile string
+	// Agent is whether to use ssh-agent ( nil: use if SSH_AUTH_SOCK is set )
+	Agent *bool
+	// PasswordAuth enables password authentication ( the password is prompted if Password is empty )
+	PasswordAuth bool
+	Password     []byte
+	// KnownHosts is the known_hosts file ( default: UserKnownHostsFile of ssh_config )
+	KnownHosts string
+	// HostKeyPolicy is "ignore" ( default ), "strict" or "accept-new"
+	HostKeyPolicy string
+}
+
+func (a *SSHAuth) validate() error {
+	switch a.HostKeyPolicy {
+	case "", HostKeyPolicyIgnore, HostKeyPolicyStrict, HostKeyPolicyAcceptNew:
+	default:
+		return fmt.Errorf("unsupport host key policy: %s", a.HostKeyPolicy)
+	}
+	if a.Agent != nil && *a.Agent && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return fmt.Errorf("ssh-agent is required, but SSH_AUTH_SOCK is not set")
+	}
+	return nil
+}
+
+// sshClient is ssh.Client with the resources released when it is closed ( the client of the previous jump host and the ProxyCommand process )
+type sshClient struct {
+	*ssh.Client
+	closers []func() error
+}
+
+// Close closes the client and releases the resources
+func (c *sshClient) Close() error {
+	err := c.Client.Close()
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		_ = c.closers[i]()
+	}
+	return err
+}
+
+// dialSSH connects to dest through jumpHosts, and gives up when ctx is done.
+// If jumpHosts is empty, ProxyJump of ssh_config is used.
+// The clients of the jump hosts are closed with the returned client.
+func dialSSH(ctx context.Context, dest sshDest, jumpHosts []string, passphrase []byte, auth SSHAuth) (*sshClient, error) {
+	cfg, err := sshc.NewConfig(dest.host)
+	if err != nil {
+		return nil, err
+	}
+	if len(jumpHosts) == 0 {
+		if pj := cfg.Get(dest.host, "ProxyJump"); pj != "" && pj != "none" {
+			jumpHosts = strings.Split(pj, ",")
+		}
+	}
+	var via *sshClient
+	closeVia := func() {
+		if via != nil {
+			_ = via.Close()
+		}
+	}
+	for _, h := range jumpHosts {
+		hop, err := parseSSHDest(strings.TrimSpace(h))
+		if err != nil {
+			closeVia()
+			return nil, err
+		}
+		hopCfg, err := sshc.NewConfig(hop.host)
+		if err != nil {
+			closeVia()
+			return nil, err
+		}
+		hopAuth := SSHAuth{
+			IdentityFile:  auth.IdentityFile,
+			Agent:         auth.Agent,
+			KnownHosts:    auth.KnownHosts,
+			HostKeyPolicy: auth.HostKeyPolicy,
+		}
+		c, err := dialSSHVia(ctx, via, hopCfg, hop, passphrase, hopAuth)
+		if err != nil {
+			closeVia()
+			return nil, fmt.Errorf("failed to connect to jump host %s: %s", hop, err)
+		}
+		via = c
+	}
+	c, err := dialSSHVia(ctx, via, cfg, dest, passphrase, auth)
+	if err != nil {
+		closeVia()
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialSSHVia connects to dest directly or through the via client.
+// The via client is closed with the returned client.
+func dialSSHVia(ctx context.Context, via *sshClient, cfg *sshc.Config, dest sshDest, passphrase []byte, a SSHAuth) (*sshClient, error) {
+	dest, hostname, err := resolveSSHDest(cfg, dest)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(hostname, strconv.Itoa(dest.port))
+
+	auth, closeAgent, err := sshAuthMethods(cfg, dest, passphrase, a)
+	if err != nil {
+		return nil, err
+	}
+	// ssh-agent is used only for the authentication
+	defer closeAgent()
+	hostKeyCallback, err := sshHostKeyCallback(cfg, dest, a)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig := &ssh.ClientConfig{
+		User:            dest.user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	}
+
+	if via != nil {
+		conn, err := dialVia(ctx, via.Client, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		c, err := newSSHClientConn(ctx, conn, addr, clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &sshClient{Client: c, closers: []func() error{via.Close}}, nil
+	}
+
+	proxyCommand := cfg.Get(dest.host, "ProxyCommand")
+	if proxyCommand != "" && proxyCommand != "none" {
+		return dialSSHWithProxyCommand(ctx, proxyCommand, hostname, dest, addr, clientConfig)
+	}
+
+	d := net.Dialer{Timeout: sshDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSSHClientConn(ctx, conn, addr, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &sshClient{Client: c}, nil
+}
+
+// resolveSSHDest fills the user and the port of dest with ssh_config ( or the current user ), and returns it with the hostname to connect
+func resolveSSHDest(cfg *sshc.Config, dest sshDest) (sshDest, string, error) {
+	hostname := cfg.Get(dest.host, "Hostname")
+	if hostname == "" {
+		hostname = dest.host
+	}
+	if dest.user == "" {
+		dest.user = cfg.Get(dest.host, "User")
+	}
+	if dest.user == "" {
+		u, err := user.Current()
+		if err != nil {
+			return sshDest{}, "", err
+		}
+		dest.user = u.Username
+	}
+	if dest.port == 0 {
+		p, err := strconv.Atoi(cfg.Get(dest.host, "Port"))
+		if err != nil {
+			return sshDest{}, "", err
+		}
+		dest.port = p
+	}
+	return dest, hostname, nil
+}
+
+// SSHDest returns the destination of the host ( user@host:port ) as it is connected, with the user and the port filled by ssh_config
+func SSHDest(host, user string, port int) (string, error) {
+	cfg, err := sshc.NewConfig(host)
+	if err != nil {
+		return "", err
+	}
+	dest, _, err := resolveSSHDest(cfg, sshDest{host: host, user: user, port: port})
+	if err != nil {
+		return "", err
+	}
+	return dest.String(), nil
+}
+
+// dialVia connects to addr through the client, and gives up when ctx is done or sshDialTimeout passes
+func dialVia(ctx context.Context, via *ssh.Client, network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, sshDialTimeout)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	resChan := make(chan result, 1)
+	go func() {
+		conn, err := via.Dial(network, addr)
+		resChan <- result{conn, err}
+	}()
+	select {
+	case r := <-resChan:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			// the connection established after giving up is not used
+			if r := <-resChan; r.err == nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// newSSHClientConn runs the SSH handshake over conn, and gives up when ctx is done or sshDialTimeout passes.
+// conn is closed if the handshake fails or is given up.
+func newSSHClientConn(ctx context.Context, conn net.Conn, addr string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	// ssh.ClientConfig.Timeout bounds only the TCP dial of ssh.Dial, and conns through jump hosts do not support deadlines
+	hctx, cancel := context.WithTimeout(ctx, sshDialTimeout)
+	defer cancel()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-hctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	close(stop)
+	<-stopped
+	if hctx.Err() != nil {
+		if err == nil {
+			_ = c.Close()
+		}
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ssh handshake timeout(%s)", sshDialTimeout)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialSSHWithProxyCommand connects to dest through the ProxyCommand process.
+// The process is killed when the connection fails or the returned client is closed.
+func dialSSHWithProxyCommand(ctx context.Context, proxyCommand, hostname string, dest sshDest, addr string, clientConfig *ssh.ClientConfig) (*sshClient, error) {
+	proxyCommand = strings.Replace(proxyCommand, "%h", hostname, -1)
+	proxyCommand = strings.Replace(proxyCommand, "%p", strconv.Itoa(dest.port), -1)
+	proxyCommand = strings.Replace(proxyCommand, "%r", dest.user, -1)
+
+	client, server := net.Pipe()
+	cmd := exec.Command("sh", "-c", proxyCommand) // #nosec
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdin = server
+	cmd.Stdout = server
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	stop := func() error {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		_ = client.Close()
+		_ = server.Close()
+		_ = cmd.Wait()
+		return nil
+	}
+
+	done := make(chan *ssh.Client, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		c, err := newSSHClientConn(ctx, client, addr, clientConfig)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		done <- c
+	}()
+
+	select {
+	case err := <-errChan:
+		_ = stop()
+		return nil, err
+	case <-ctx.Done():
+		_ = stop()
+		return nil, ctx.Err()
+	case <-time.After(proxyCommandTimeout):
+		_ = stop()
+		return nil, fmt.Errorf("proxy command timeout(%s)", proxyCommandTimeout)
+	case c := <-done:
+		return &sshClient{Client: c, closers: []func() error{stop}}, nil
+	}
+}
+
+// sshAuthMethods returns auth methods using ssh-agent, IdentityFile of ssh_config ( or SSHAuth ) and password, and the function closing the connection to ssh-agent
+func sshAuthMethods(cfg *sshc.Config, dest sshDest, passphrase []byte, a SSHAuth) ([]ssh.AuthMethod, func(), error) {
+	auth := []ssh.AuthMethod{}
+	closeAgent := func() {}
+
+	if a.Agent == nil || *a.Agent {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			conn, err := net.Dial("unix", sock)
+			if err == nil {
+				auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+				closeAgent = func() {
+					_ = conn.Close()
+				}
+			} else if a.Agent != nil {
+				return nil, closeAgent, err
+			}
+		}
+	}
+
+	signer, err := identitySigner(cfg, dest, passphrase, a)
+	if err == nil {
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if _, ok := err.(*os.PathError); !ok || a.IdentityFile != "" || (len(auth) == 0 && !a.PasswordAuth) {
+		// the identity file of ssh_config is not required if other methods are available
+		closeAgent()
+		return nil, func() {}, err
+	}
+
+	if a.PasswordAuth {
+		password := func() (string, error) {
+			if len(a.Password) > 0 {
+				return string(a.Password), nil
+			}
+			p, err := PromptCredential(PasswordKey(dest.String()), fmt.Sprintf("Enter password for '%s'", dest))
+			return string(p), err
+		}
+		auth = append(auth, ssh.PasswordCallback(password))
+		auth = append(auth, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range questions {
+				if echos[i] {
+					continue
+				}
+				p, err := password()
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = p
+			}
+			return answers, nil
+		}))
+	}
+
+	return auth, closeAgent, nil
+}
+
+// identitySigner returns the signer of the identity file ( IdentityFile of SSHAuth or ssh_config )
+func identitySigner(cfg *sshc.Config, dest sshDest, passphrase []byte, a SSHAuth) (ssh.Signer, error) {
+	keyPath := a.IdentityFile
+	if keyPath != "" {
+		p, err := expandPath(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		keyPath = p
+	} else {
+		p, err := identityFile(cfg, dest)
+		if err != nil {
+			return nil, err
+		}
+		keyPath = p
+	}
+	key, err := ioutil.ReadFile(filepath.Clean(keyPath))
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if len(passphrase) == 0 {
+			passphrase, err = PromptCredential(PassphraseKey(keyPath), fmt.Sprintf("Enter passphrase for key '%s'", keyPath))
+			if err != nil {
+				return nil, err
+			}
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// IdentityFileNeedsPassphrase returns the identity file of the host ( identityFile or IdentityFile of ssh_config ), and whether it is encrypted
+func IdentityFileNeedsPassphrase(host, user, keyFile string) (string, bool, error) {
+	cfg, err := sshc.NewConfig(host)
+	if err != nil {
+		return "", false, err
+	}
+	dest, _, err := resolveSSHDest(cfg, sshDest{host: host, user: user})
+	if err != nil {
+		return "", false, err
+	}
+	keyPath := keyFile
+	if keyPath != "" {
+		keyPath, err = expandPath(keyPath)
+	} else {
+		keyPath, err = identityFile(cfg, dest)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	key, err := ioutil.ReadFile(filepath.Clean(keyPath))
+	if err != nil {
+		return keyPath, false, nil
+	}
+	_, err = ssh.ParsePrivateKey(key)
+	_, missing := err.(*ssh.PassphraseMissingError)
+	return keyPath, missing, nil
+}
+
+// sshHostKeyCallback returns the callback verifying the host key by the policy of SSHAuth
+func sshHostKeyCallback(cfg *sshc.Config, dest sshDest, a SSHAuth) (ssh.HostKeyCallback, error) {
+	if a.HostKeyPolicy == "" || a.HostKeyPolicy == HostKeyPolicyIgnore {
+		return ssh.InsecureIgnoreHostKey(), nil // #nosec
+	}
+	path := a.KnownHosts
+	if path == "" {
+		fields := strings.Fields(cfg.Get(dest.host, "UserKnownHostsFile"))
+		if len(fields) > 0 {
+			path = fields[0]
+		} else {
+			path = "~/.ssh/known_hosts"
+		}
+	}
+	path, err := expandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return newHostKeyCallback(path, a.HostKeyPolicy)
+}
+
+var knownHostsMu sync.Mutex
+
+// newHostKeyCallback returns the callback verifying the host key using the known_hosts file.
+// With accept-new, the keys of unknown hosts are added to the file, and changed keys are rejected.
+func newHostKeyCallback(path, policy string) (ssh.HostKeyCallback, error) {
+	if policy == HostKeyPolicyAcceptNew {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		_ = f.Close()
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+		cb, err := knownhosts.New(path)
+		if err != nil {
+			return err
+		}
+		err = cb(hostname, remote, key)
+		ke, ok := err.(*knownhosts.KeyError)
+		if !ok || len(ke.Want) > 0 || policy != HostKeyPolicyAcceptNew {
+			if ok && len(ke.Want) == 0 {
+				return fmt.Errorf("host key of %s is not found in %s", hostname, path)
+			}
+			return err
+		}
+		f, err := os.OpenFile(filepath.Clean(path), os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}, nil
+}
+
+func expandPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return p, nil
+	}
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(p, "~", homeDir, 1), nil
+}
+
+func identityFile(cfg *sshc.Config, dest sshDest) (string, error) {
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	keyPath := cfg.Get(dest.host, "IdentityFile")
+	keyPath = strings.Replace(keyPath, "%h", dest.host, -1)
+	keyPath = strings.Replace(keyPath, "%r", dest.user, -1)
+	keyPath = strings.Replace(keyPath, "~", homeDir, 1)
+	if keyPath != filepath.Join(homeDir, ".ssh", "identity") {
+		return keyPath, nil
+	}
+	for _, k := range []string{"identity", "id_rsa", "id_ecdsa", "id_ed25519"} {
+		p := filepath.Join(homeDir, ".ssh", k)
+		if _, err := os.Lstat(p); err == nil {
+			return p, nil
+		}
+	}
+	return filepath.Join(homeDir, ".ssh", "id_rsa"), nil
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// DefaultSSHMaxSessions is same as the default MaxSessions of sshd
+const DefaultSSHMaxSessions = 10
+
+// sshKeepAliveTimeout is the timeout of the keepalive request checking the shared connection
+const sshKeepAliveTimeout = 10 * time.Second
+
+// sshConn is a SSH connection shared by SSHClients
+type sshConn struct {
+	client   *sshClient
+	sessions chan struct{}
+	tzMu     sync.Mutex
+	tz       string
+	tzCached bool
+	sftpMu   sync.Mutex
+	sftp     *sftp.Client
+}
+
+func newSSHConn(client *sshClient, maxSessions int) *sshConn {
+	c := &sshConn{
+		client: client,
+	}
+	if maxSessions > 0 {
+		c.sessions = make(chan struct{}, maxSessions)
+	}
+	return c
+}
+
+// acquire waits for a free session slot
+func (c *sshConn) acquire(ctx context.Context) (func(), error) {
+	if c.sessions == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.sessions <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-c.sessions
+		})
+	}, nil
+}
+
+// timeZone returns the time zone offset of the remote host ( cached on success )
+func (c *sshConn) timeZone(ctx context.Context) (string, error) {
+	c.tzMu.Lock()
+	defer c.tzMu.Unlock()
+	if c.tzCached {
+		return c.tz, nil
+	}
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	session, err := c.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	tzCmd := `date +"%z"`
+	tzOut, err := session.Output(tzCmd)
+	if err != nil {
+		return "", err
+	}
+	c.tz = strings.TrimRight(string(tzOut), "\n")
+	c.tzCached = true
+	return c.tz, nil
+}
+
+// sftpClient returns the SFTP client shared in the connection
+func (c *sshConn) sftpClient(ctx context.Context) (*sftp.Client, error) {
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+	if c.sftp != nil {
+		return c.sftp, nil
+	}
+	// the SFTP subsystem keeps using a session slot until the connection is closed
+	if _, err := c.acquire(ctx); err != nil {
+		return nil, err
+	}
+	sc, err := sftp.NewClient(c.client.Client)
+	if err != nil {
+		if c.sessions != nil {
+			<-c.sessions
+		}
+		return nil, err
+	}
+	c.sftp = sc
+	return sc, nil
+}
+
+// alive returns whether the connection responds to the keepalive request
+func (c *sshConn) alive() bool {
+	errChan := make(chan error, 1)
+	go func() {
+		// the server replies even if it does not support the request
+		_, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
+		errChan <- err
+	}()
+	select {
+	case err := <-errChan:
+		return err == nil
+	case <-time.After(sshKeepAliveTimeout):
+		return false
+	}
+}
+
+func (c *sshConn) close() error {
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+	if c.sftp != nil {
+		_ = c.sftp.Close()
+	}
+	return c.client.Close()
+}
+
+// SSHPool shares SSH connections per user@host:port across targets.
+// Connections are not shared between targets with different jump hosts, authentication settings or session limits.
+type SSHPool struct {
+	mu    sync.Mutex
+	conns map[string]*sshPoolEntry
+	dial  func(ctx context.Context, dest sshDest, jumpHosts []string, passphrase []byte, auth SSHAuth) (*sshClient, error)
+}
+
+// sshPoolEntry is the connection in the pool. lock is held while checking and dialing the connection, so that waiting for it can be canceled.
+type sshPoolEntry struct {
+	lock chan struct{}
+	conn *sshConn
+}
+
+// NewSSHPool ...
+func NewSSHPool() *SSHPool {
+	return &SSHPool{
+		conns: map[string]*sshPoolEntry{},
+		dial:  dialSSH,
+	}
+}
+
+// get returns the connection for dest. If not connected yet or the connection is dead, dial it.
+// Waiting for other targets dialing the same connection and dialing are given up when ctx is done.
+func (p *SSHPool) get(ctx context.Context, dest sshDest, jumpHosts []string, passphrase []byte, auth SSHAuth, maxSessions int) (*sshConn, error) {
+	key := sshPoolKey(dest, jumpHosts, auth, maxSessions)
+	p.mu.Lock()
+	e, ok := p.conns[key]
+	if !ok {
+		e = &sshPoolEntry{lock: make(chan struct{}, 1)}
+		p.conns[key] = e
+	}
+	p.mu.Unlock()
+
+	select {
+	case e.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-e.lock
+	}()
+	if e.conn != nil {
+		if e.conn.alive() {
+			return e.conn, nil
+		}
+		// the connection is closed by the server or the network ( e.g. idle timeout ), so reconnect
+		_ = e.conn.close()
+		e.conn = nil
+	}
+	client, err := p.dial(ctx, dest, jumpHosts, passphrase, auth)
+	if err != nil {
+		return nil, err
+	}
+	e.conn = newSSHConn(client, maxSessions)
+	return e.conn, nil
+}
+
+// sshPoolKey returns the key of the connection in the pool, so that a connection verified or authenticated
+// with some settings ( e.g. sshHostKeyPolicy: ignore ) is not reused by targets with other settings,
+// and the session limit of a connection is not set by the target dialing first
+func sshPoolKey(dest sshDest, jumpHosts []string, auth SSHAuth, maxSessions int) string {
+	agent := ""
+	if auth.Agent != nil {
+		agent = fmt.Sprintf("%t", *auth.Agent)
+	}
+	password := ""
+	if len(auth.Password) > 0 {
+		password = fmt.Sprintf("%x", sha256.Sum256(auth.Password))
+	}
+	policy := auth.HostKeyPolicy
+	if policy == "" {
+		policy = HostKeyPolicyIgnore
+	}
+	return strings.Join([]string{
+		dest.String(),
+		strings.Join(jumpHosts, ","),
+		auth.IdentityFile,
+		agent,
+		fmt.Sprintf("%t", auth.PasswordAuth),
+		password,
+		auth.KnownHosts,
+		policy,
+		strconv.Itoa(maxSessions),
+	}, "\x00")
+}
+
+// Close closes all connections in the pool
+func (p *SSHPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for key, e := range p.conns {
+		e.lock <- struct{}{}
+		if e.conn != nil {
+			if cErr := e.conn.close(); cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+		<-e.lock
+		delete(p.conns, key)
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StdinClient reads logs piped to stdin
+type StdinClient struct {
+	r        io.Reader
+	host     string
+	path     string
+	lineChan chan Line
+	logger   *zap.Logger
+}
+
+// StdinOption ...
+type StdinOption func(*StdinClient) error
+
+// StdinReader reads logs from r instead of os.Stdin
+func StdinReader(r io.Reader) StdinOption {
+	return func(c *StdinClient) error {
+		c.r = r
+		return nil
+	}
+}
+
+// NewStdinClient returns StdinClient. host and path are used as the labels of the logs.
+func NewStdinClient(l *zap.Logger, host, path string, opts ...StdinOption) (Client, error) {
+	c := &StdinClient{
+		r:        os.Stdin,
+		host:     host,
+		path:     path,
+		lineChan: make(chan Line),
+		logger:   l,
+	}
+	for _, opt := range opts {
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Read reads logs from stdin ( compressed logs are decompressed )
+func (c *StdinClient) Read(ctx context.Context, st, et *time.Time, timeFormat, timeZone string) error {
+	r, err := newDecompressReader(c.r)
+	if err != nil {
+		close(c.lineChan)
+		return err
+	}
+	return bindReaderAndChan(ctx, c.logger, &r, c.lineChan, c.host, c.path, localTimeZone())
+}
+
+// Tailf reads logs from stdin until EOF
+func (c *StdinClient) Tailf(ctx context.Context) error {
+	return bindReaderAndChan(ctx, c.logger, &c.r, c.lineChan, c.host, c.path, localTimeZone())
+}
+
+// Ls ...
+func (c *StdinClient) Ls(ctx context.Context, st *time.Time, et *time.Time) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	c.lineChan <- Line{
+		Host:     c.host,
+		Path:     c.path,
+		Content:  c.path,
+		TimeZone: "",
+	}
+	return nil
+}
+
+// Copy ...
+func (c *StdinClient) Copy(ctx context.Context, filePath string, dstDir string, st *time.Time, et *time.Time) error {
+	return fmt.Errorf("not supported: copy stdin sources: %s", filePath)
+}
+
+// RandomOne reads the first line from stdin
+func (c *StdinClient) RandomOne(ctx context.Context) error {
+	defer func() {
+		c.logger.Debug("Close chan client.Line")
+		close(c.lineChan)
+	}()
+	r, err := newDecompressReader(c.r)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, initialScanTokenSize)
+	scanner.Buffer(buf, maxScanTokenSize)
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+	c.lineChan <- Line{
+		Host:     c.host,
+		Path:     c.path,
+		Content:  scanner.Text(),
+		TimeZone: localTimeZone(),
+	}
+	return nil
+}
+
+// Out ...
+func (c *StdinClient) Out() <-chan Line {
+	return c.lineChan
+}
+
+// localTimeZone returns the time zone of the local host ( like `date +%z` )
+func localTimeZone() string {
+	return time.Now().Format("-0700")
+}
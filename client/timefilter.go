@@ -0,0 +1,328 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTimePrefixes is the maximum number of prefixes of timeFilter ( if exceeded, the common prefix of st and et is used )
+const maxTimePrefixes = 256
+
+// timeFilter selects lines containing one of the prefixes of formatted times between st and et.
+// For multi-line logs, lines matching head start records, and the other lines are selected with the preceding record.
+type timeFilter struct {
+	prefixes []string
+	head     string
+}
+
+// newTimeFilter returns timeFilter of the time range ( or nil if lines can not be selected by the formatted times ).
+// timeZone is the time zone of the timestamps of lines ( e.g. +0900 ), and head is the regexp of the first lines of multi-line records ( "" for single-line logs ).
+func newTimeFilter(st, et *time.Time, timeFormat, timeZone, head string) *timeFilter {
+	if st == nil || et == nil || timeFormat == "" {
+		return nil
+	}
+	if hasZoneName(timeFormat) {
+		// zone names ( e.g. JST ) can not be formatted from the offset of timeZone
+		return nil
+	}
+	loc := time.UTC
+	if timeZone != "" {
+		if z, err := time.Parse("-0700", timeZone); err == nil {
+			loc = z.Location()
+		}
+	}
+	format := func(t time.Time) string {
+		s := t.In(loc).Format(timeFormat)
+		// for syslog timestamp
+		return syslogTimestampAMRe.ReplaceAllString(s, "$1  $2")
+	}
+	units := []time.Duration{24 * time.Hour, time.Hour, 10 * time.Minute, time.Minute, 10 * time.Second, time.Second}
+	if timeFormat == "unixtime" {
+		format = func(t time.Time) string {
+			return strconv.FormatInt(t.Unix(), 10)
+		}
+		units = []time.Duration{100000 * time.Second, 10000 * time.Second, 1000 * time.Second, 100 * time.Second, 10 * time.Second, time.Second}
+	}
+	prefixes := timePrefixes(*st, *et, format, units)
+	if prefixes == nil {
+		prefixes = []string{commonPrefix(format(*st), format(*et))}
+	}
+	for _, p := range prefixes {
+		if p == "" {
+			return nil
+		}
+	}
+	return &timeFilter{
+		prefixes: prefixes,
+		head:     head,
+	}
+}
+
+// timePrefixes returns the prefixes of formatted times covering st to et ( or nil if more than maxTimePrefixes are required ).
+// The range is divided into the largest blocks of units whose formatted times share the prefix that the times around the block do not have.
+func timePrefixes(st, et time.Time, format func(time.Time) string, units []time.Duration) []string {
+	prefixes := []string{}
+	t := st.Truncate(units[len(units)-1])
+	for !t.After(et) {
+		var (
+			prefix string
+			unit   time.Duration
+		)
+		for i, u := range units {
+			last := t.Add(u - time.Nanosecond)
+			smallest := i == len(units)-1
+			if last.After(et) && !smallest {
+				continue
+			}
+			prefix = commonPrefix(format(t), format(last))
+			unit = u
+			if smallest || (!strings.HasPrefix(format(t.Add(-time.Nanosecond)), prefix) && !strings.HasPrefix(format(t.Add(u)), prefix)) {
+				break
+			}
+		}
+		if len(prefixes) == 0 || prefixes[len(prefixes)-1] != prefix {
+			prefixes = append(prefixes, prefix)
+		}
+		if len(prefixes) > maxTimePrefixes {
+			return nil
+		}
+		t = t.Add(unit)
+	}
+	return prefixes
+}
+
+// hasZoneName reports whether the layout contains the zone name ( MST )
+func hasZoneName(layout string) bool {
+	if layout == "unixtime" {
+		return false
+	}
+	t := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	return t.In(time.FixedZone("A", 0)).Format(layout) != t.In(time.FixedZone("B", 0)).Format(layout)
+}
+
+func commonPrefix(a, b string) string {
+	ar := []rune(a)
+	br := []rune(b)
+	i := 0
+	for i < len(ar) && i < len(br) && ar[i] == br[i] {
+		i++
+	}
+	return string(ar[:i])
+}
+
+// command returns the pipeline selecting lines on the remote host ( grep for single-line logs, awk for multi-line logs ), or "".
+// If the regexp of the head can not be used by awk, lines are not selected on the remote host.
+func (f *timeFilter) command() string {
+	if f == nil {
+		return ""
+	}
+	if f.head == "" {
+		return " | " + buildGrepFCommand(f.prefixes, false, false)
+	}
+	ere, err := toERE(f.head)
+	if err != nil {
+		return ""
+	}
+	conds := []string{}
+	for _, p := range f.prefixes {
+		conds = append(conds, fmt.Sprintf("index($0, %s)", awkString(p)))
+	}
+	program := fmt.Sprintf("/%s/ { k = %s } k", ere, strings.Join(conds, " || "))
+	return fmt.Sprintf(" | awk %s", shellQuote(program))
+}
+
+// selector returns the function selecting lines in the same way as command ( for reading via SFTP )
+func (f *timeFilter) selector() (func(line []byte) bool, error) {
+	if f == nil {
+		return func(line []byte) bool { return true }, nil
+	}
+	var head *regexp.Regexp
+	if f.head != "" {
+		re, err := regexp.Compile(f.head)
+		if err != nil {
+			return nil, err
+		}
+		head = re
+	}
+	prefixes := [][]byte{}
+	for _, p := range f.prefixes {
+		prefixes = append(prefixes, []byte(p))
+	}
+	keep := false
+	return func(line []byte) bool {
+		if head != nil && !head.Match(line) {
+			return keep
+		}
+		keep = false
+		for _, p := range prefixes {
+			if bytes.Contains(line, p) {
+				keep = true
+				break
+			}
+		}
+		return keep
+	}, nil
+}
+
+// awkString returns the string literal of awk
+func awkString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return fmt.Sprintf(`"%s"`, r.Replace(s))
+}
+
+// toERE translates the regexp ( RE2 syntax ) into the POSIX extended regular expression for awk
+func toERE(s string) (string, error) {
+	re, err := syntax.Parse(s, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	return ereOf(re.Simplify())
+}
+
+func ereOf(re *syntax.Regexp) (string, error) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		b := strings.Builder{}
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && strings.ToLower(string(r)) != strings.ToUpper(string(r)) {
+				if r >= utf8.RuneSelf {
+					return "", fmt.Errorf("unsupported case folding: %s", re)
+				}
+				b.WriteString(fmt.Sprintf("[%s%s]", strings.ToLower(string(r)), strings.ToUpper(string(r))))
+				continue
+			}
+			b.WriteString(ereLiteral(r))
+		}
+		return b.String(), nil
+	case syntax.OpCharClass:
+		return ereCharClass(re.Rune)
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return ".", nil
+	case syntax.OpBeginLine, syntax.OpBeginText:
+		return "^", nil
+	case syntax.OpEndLine, syntax.OpEndText:
+		return "$", nil
+	case syntax.OpCapture:
+		sub, err := ereOf(re.Sub[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s)", sub), nil
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		sub, err := ereOf(re.Sub[0])
+		if err != nil {
+			return "", err
+		}
+		if !ereAtom(re.Sub[0]) {
+			sub = fmt.Sprintf("(%s)", sub)
+		}
+		op := map[syntax.Op]string{syntax.OpStar: "*", syntax.OpPlus: "+", syntax.OpQuest: "?"}[re.Op]
+		return sub + op, nil
+	case syntax.OpConcat:
+		subs := []string{}
+		for _, sub := range re.Sub {
+			s, err := ereOf(sub)
+			if err != nil {
+				return "", err
+			}
+			subs = append(subs, s)
+		}
+		return strings.Join(subs, ""), nil
+	case syntax.OpAlternate:
+		subs := []string{}
+		for _, sub := range re.Sub {
+			s, err := ereOf(sub)
+			if err != nil {
+				return "", err
+			}
+			subs = append(subs, s)
+		}
+		return fmt.Sprintf("(%s)", strings.Join(subs, "|")), nil
+	}
+	return "", fmt.Errorf("unsupported regexp for awk: %s", re)
+}
+
+// ereAtom reports whether the ERE of re can be repeated without the group
+func ereAtom(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune) == 1 && re.Rune[0] < utf8.RuneSelf && re.Flags&syntax.FoldCase == 0
+	case syntax.OpCharClass, syntax.OpAnyCharNotNL, syntax.OpAnyChar, syntax.OpCapture:
+		return true
+	}
+	return false
+}
+
+func ereLiteral(r rune) string {
+	switch r {
+	case '\\', '^', '$', '.', '[', ']', '|', '(', ')', '*', '+', '?', '{', '}', '/':
+		return `\` + string(r)
+	case '\t':
+		return `\t`
+	}
+	return string(r)
+}
+
+// ereCharClass returns the bracket expression of the ranges ( pairs of runes ).
+// Classes of non-ASCII runes are supported only when negated ( e.g. \S ), because awk may not handle multibyte characters in bracket expressions.
+func ereCharClass(ranges []rune) (string, error) {
+	negated := false
+	if len(ranges) > 0 && ranges[len(ranges)-1] == utf8.MaxRune {
+		// complement of the ranges
+		comp := []rune{}
+		next := rune(0)
+		for i := 0; i < len(ranges); i += 2 {
+			if ranges[i] > next {
+				comp = append(comp, next, ranges[i]-1)
+			}
+			next = ranges[i+1] + 1
+		}
+		ranges = comp
+		negated = true
+	}
+	if len(ranges) == 0 {
+		if negated {
+			return ".", nil
+		}
+		return "", fmt.Errorf("empty character class")
+	}
+	items := []string{}
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		if hi >= utf8.RuneSelf {
+			return "", fmt.Errorf("unsupported character class for awk")
+		}
+		if hi-lo < 3 {
+			for r := lo; r <= hi; r++ {
+				items = append(items, ereClassChar(r))
+			}
+			continue
+		}
+		items = append(items, fmt.Sprintf("%s-%s", ereClassChar(lo), ereClassChar(hi)))
+	}
+	if negated {
+		return fmt.Sprintf("[^%s]", strings.Join(items, "")), nil
+	}
+	return fmt.Sprintf("[%s]", strings.Join(items, "")), nil
+}
+
+func ereClassChar(r rune) string {
+	switch r {
+	case '\\', ']', '[', '^', '-', '/':
+		return `\` + string(r)
+	case '\t':
+		return `\t`
+	case '\n':
+		return `\n`
+	}
+	if r < 0x20 || r == 0x7f {
+		return fmt.Sprintf(`\%03o`, r)
+	}
+	return string(r)
+}
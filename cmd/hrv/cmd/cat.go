@@ -87,6 +87,7 @@ var catCmd = &cobra.Command{
 			withHost,
 			withPath,
 			withTag,
+			withFields,
 			withoutMark,
 			hLen,
 			tLen,
@@ -208,6 +209,7 @@ func init() {
 	catCmd.Flags().BoolVarP(&withHost, "with-host", "", false, "output with host")
 	catCmd.Flags().BoolVarP(&withPath, "with-path", "", false, "output with path")
 	catCmd.Flags().BoolVarP(&withTag, "with-tag", "", false, "output with tag")
+	catCmd.Flags().BoolVarP(&withFields, "with-fields", "", false, "output with structured fields ( e.g. priority of journald )")
 	catCmd.Flags().BoolVarP(&withoutMark, "without-mark", "", false, "output without prefix mark")
 	catCmd.Flags().StringVarP(&match, "match", "", "", "filter logs using SQLite FTS `MATCH` query")
 	catCmd.Flags().StringVarP(&tag, "tag", "", "", "filter logs using tag")
@@ -26,6 +26,7 @@ import (
 	"os"
 	"sync"
 
+	"github.com/k1LoW/harvest/client"
 	"github.com/k1LoW/harvest/collector"
 	"github.com/k1LoW/harvest/config"
 	"github.com/k1LoW/harvest/logger"
@@ -68,8 +69,8 @@ var configtestCmd = &cobra.Command{
 		}
 		l.Info(fmt.Sprintf("Target count: %d", len(targets)))
 
-		if presetSSHKeyPassphrase {
-			err = presetSSHKeyPassphraseToTargets(targets)
+		if presetCredentials {
+			err = presetCredentialsToTargets(targets)
 			if err != nil {
 				l.Error("option error", zap.String("error", err.Error()))
 				os.Exit(1)
@@ -79,6 +80,8 @@ var configtestCmd = &cobra.Command{
 		l.Info("Test timestamp parsing")
 		fmt.Println("")
 
+		pool := client.NewSSHPool()
+		defer pool.Close()
 		cChan := make(chan struct{}, 1)
 		var wg sync.WaitGroup
 
@@ -86,7 +89,7 @@ var configtestCmd = &cobra.Command{
 		for _, t := range targets {
 			wg.Add(1)
 			cChan <- struct{}{}
-			c, err := collector.NewCollector(ctx, t, l)
+			c, err := collector.NewCollector(ctx, t, l, collector.SSHPool(pool))
 			if err != nil {
 				failure++
 				<-cChan
@@ -95,8 +98,13 @@ var configtestCmd = &cobra.Command{
 				continue
 			}
 			logChan := make(chan parser.Log)
+			failed := make(chan bool, 1)
 			go func(t *config.Target, logChan chan parser.Log) {
 				defer wg.Done()
+				f := false
+				defer func() {
+					failed <- f
+				}()
 				fmt.Printf("%s: ", t.Source)
 				logRead := false
 				for log := range logChan {
@@ -115,18 +123,20 @@ var configtestCmd = &cobra.Command{
 						fmt.Printf("    %s %s\n", color.Red(" MultiLine:"), color.Red(t.MultiLine))
 						fmt.Printf("    %s %s\n", color.Red("       Log:"), color.Red(log.Content))
 						fmt.Println("")
-						failure++
+						f = true
 					}
 					logRead = true
 				}
 				if !logRead {
 					fmt.Printf("%s\n", color.Red("Log read error", color.B))
-					failure++
+					f = true
 				}
 			}(t, logChan)
 			err = c.ConfigTest(logChan, t.MultiLine)
-			if err != nil {
+			if <-failed || err != nil {
 				failure++
+			}
+			if err != nil {
 				l.Error("ConfigTest error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
 			}
 			<-cChan
@@ -149,6 +159,10 @@ func init() {
 	_ = configtestCmd.MarkFlagFilename("config", "yaml", "yml")
 	configtestCmd.Flags().StringVarP(&tag, "tag", "", "", "filter targets using tag")
 	configtestCmd.Flags().StringVarP(&sourceRe, "source", "", "", "filter targets using source regexp")
-	configtestCmd.Flags().BoolVarP(&presetSSHKeyPassphrase, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	configtestCmd.Flags().BoolVarP(&presetCredentials, "preset-credentials", "", false, "preset credentials ( SSH key passphrases, SSH passwords and the sudo password for become )")
+	configtestCmd.Flags().BoolVarP(&presetCredentials, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	_ = configtestCmd.Flags().MarkDeprecated("preset-ssh-key-passphrase", "use --preset-credentials instead")
+	configtestCmd.Flags().BoolVarP(&presetCredentials, "preset-become-password", "", false, "preset sudo password for become")
+	_ = configtestCmd.Flags().MarkDeprecated("preset-become-password", "use --preset-credentials instead")
 	configtestCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print debugging messages.")
 }
@@ -28,6 +28,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/k1LoW/harvest/client"
 	"github.com/k1LoW/harvest/collector"
 	"github.com/k1LoW/harvest/config"
 	"github.com/k1LoW/harvest/logger"
@@ -90,8 +91,8 @@ var cpCmd = &cobra.Command{
 		}
 		l.Info(fmt.Sprintf("Target count: %d", len(targets)))
 
-		if presetSSHKeyPassphrase {
-			err = presetSSHKeyPassphraseToTargets(targets)
+		if presetCredentials {
+			err = presetCredentialsToTargets(targets)
 			if err != nil {
 				l.Error("option error", zap.String("error", err.Error()))
 				os.Exit(1)
@@ -116,6 +117,7 @@ var cpCmd = &cobra.Command{
 			false,
 			false,
 			false,
+			false,
 			0,
 			0,
 			noColor,
@@ -130,6 +132,8 @@ var cpCmd = &cobra.Command{
 
 		go sout.Out(logChan, hosts)
 
+		pool := client.NewSSHPool()
+		defer pool.Close()
 		cChan := make(chan struct{}, concurrency)
 		var wg sync.WaitGroup
 
@@ -137,16 +141,19 @@ var cpCmd = &cobra.Command{
 			wg.Add(1)
 			go func(t *config.Target) {
 				cChan <- struct{}{}
-				defer wg.Done()
-				c, err := collector.NewCollector(ctx, t, l)
+				defer func() {
+					<-cChan
+					wg.Done()
+				}()
+				c, err := collector.NewCollector(ctx, t, l, collector.SSHPool(pool))
 				if err != nil {
 					l.Error("Copy error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
+					return
 				}
 				err = c.Copy(logChan, st, et, dstDir)
 				if err != nil {
 					l.Error("Copy error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
 				}
-				<-cChan
 			}(t)
 		}
 
@@ -167,6 +174,10 @@ func init() {
 	cpCmd.Flags().StringVarP(&stStr, "start-time", "", "", "log start time (format: 2006-01-02 15:04:05)")
 	cpCmd.Flags().StringVarP(&etStr, "end-time", "", "", "log end time (default: latest) (format: 2006-01-02 15:04:05)")
 	cpCmd.Flags().StringVarP(&duStr, "duration", "", "", "log duration")
-	cpCmd.Flags().BoolVarP(&presetSSHKeyPassphrase, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	cpCmd.Flags().BoolVarP(&presetCredentials, "preset-credentials", "", false, "preset credentials ( SSH key passphrases, SSH passwords and the sudo password for become )")
+	cpCmd.Flags().BoolVarP(&presetCredentials, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	_ = cpCmd.Flags().MarkDeprecated("preset-ssh-key-passphrase", "use --preset-credentials instead")
+	cpCmd.Flags().BoolVarP(&presetCredentials, "preset-become-password", "", false, "preset sudo password for become")
+	_ = cpCmd.Flags().MarkDeprecated("preset-become-password", "use --preset-credentials instead")
 	cpCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print debugging messages.")
 }
@@ -23,11 +23,16 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
+	"github.com/k1LoW/harvest/client"
 	"github.com/k1LoW/harvest/collector"
 	"github.com/k1LoW/harvest/config"
 	"github.com/k1LoW/harvest/db"
@@ -37,125 +42,487 @@ import (
 )
 
 var (
-	stStr       string
-	etStr       string
-	duStr       string
-	dbPath      string
-	concurrency int
+	stStr          string
+	etStr          string
+	duStr          string
+	dbPath         string
+	concurrency    int
+	appendDB       bool
+	sinceLast      bool
+	fetchTimeout   time.Duration
+	fetchRetry     int
+	grep           string
+	grepV          string
+	sshCompression string
 )
 
 const (
 	defaultConcurrency = 10
+	defaultFetchRetry  = 2
+	maxRetryBackoff    = 30 * time.Second
 )
 
 // fetchCmd represents the fetch command
 var fetchCmd = &cobra.Command{
-	Use:   "fetch",
+	Use:   "fetch [stdin:// | -]",
 	Short: "fetch from targets",
 	Long:  `fetch from targets.`,
+	Args:  stdinArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		l := logger.NewLogger(verbose)
+		if code := runFetch(args); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// runFetch fetches logs from the targets and returns the exit code ( after the deferred cleanups )
+func runFetch(args []string) int {
+	l := logger.NewLogger(verbose)
+
+	cfg, stdinTarget, err := loadConfig(args)
+	if err != nil {
+		l.Error("Config error", zap.String("error", err.Error()))
+		return 1
+	}
 
-		cfg, err := config.NewConfig()
+	for _, re := range []string{grep, grepV} {
+		if _, err := regexp.Compile(re); err != nil {
+			l.Error("option error", zap.String("error", err.Error()))
+			return 1
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	appendMode := appendDB || sinceLast
+	if dbPath == "" {
+		if appendMode {
+			l.Error("option error", zap.String("error", "--out is required with --append or --since-last"))
+			return 1
+		}
+		dbPath = fmt.Sprintf("harvest-%s.db", time.Now().Format("20060102T150405-0700"))
+	}
+	var d *db.DB
+	if _, err := os.Lstat(dbPath); err == nil {
+		if !appendMode {
+			l.Error(fmt.Sprintf("%s already exists ( use --append to append logs to it )", dbPath))
+			return 1
+		}
+		d, err = db.AppendDB(ctx, l, cfg, dbPath)
 		if err != nil {
-			l.Error("Config error", zap.String("error", err.Error()))
-			os.Exit(1)
+			l.Error("DB attach error", zap.String("error", err.Error()))
+			return 1
 		}
-		err = cfg.LoadConfigFile(configPath)
+	} else {
+		d, err = db.NewDB(ctx, l, cfg, dbPath)
 		if err != nil {
-			l.Error("Config error", zap.String("error", err.Error()))
-			os.Exit(1)
+			l.Error("DB initialize error", zap.String("error", err.Error()))
+			return 1
 		}
+	}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+	_ = d.SetMeta("option.tag", tag)
+	_ = d.SetMeta("option.source", sourceRe)
+	_ = d.SetMeta("option.start-time", stStr)
+	_ = d.SetMeta("option.end-time", etStr)
+	_ = d.SetMeta("option.duration", duStr)
+	_ = d.SetMeta("option.concurrency", strconv.Itoa(concurrency))
+	_ = d.SetMeta("option.append", strconv.FormatBool(appendMode))
+	_ = d.SetMeta("option.since-last", strconv.FormatBool(sinceLast))
+	_ = d.SetMeta("option.ssh-compression", sshCompression)
+	_ = d.SetMeta("option.grep", grep)
+	_ = d.SetMeta("option.grep-v", grepV)
 
-		if dbPath == "" {
-			dbPath = fmt.Sprintf("harvest-%s.db", time.Now().Format("20060102T150405-0700"))
+	targets, err := cfg.FilterTargets(tag, sourceRe)
+	if err != nil {
+		l.Error("tag option error", zap.String("error", err.Error()))
+		return 1
+	}
+	targets = withStdinTarget(targets, stdinTarget)
+	for _, t := range targets {
+		// record the filters of the target sets, so that the DB documents what was excluded
+		if len(t.Grep) > 0 {
+			_ = d.SetMeta(fmt.Sprintf("target.%d.filter.grep", t.Id), strings.Join(t.Grep, " "))
+		}
+		if len(t.GrepV) > 0 {
+			_ = d.SetMeta(fmt.Sprintf("target.%d.filter.grep-v", t.Id), strings.Join(t.GrepV, " "))
+		}
+		if t.SSHCompression == "" {
+			t.SSHCompression = sshCompression
 		}
-		if _, err := os.Lstat(dbPath); err == nil {
-			l.Error(fmt.Sprintf("%s already exists", dbPath))
-			os.Exit(1)
+		if grep != "" {
+			t.Grep = append(t.Grep, grep)
 		}
-		d, err := db.NewDB(ctx, l, cfg, dbPath)
+		if grepV != "" {
+			t.GrepV = append(t.GrepV, grepV)
+		}
+	}
+	if len(targets) == 0 {
+		l.Error("No targets")
+		return 1
+	}
+	l.Info(fmt.Sprintf("Target count: %d", len(targets)))
+
+	_ = d.SetMeta("fetch.target-count", strconv.Itoa(len(targets)))
+
+	if presetCredentials {
+		err = presetCredentialsToTargets(targets)
 		if err != nil {
-			l.Error("DB initialize error", zap.String("error", err.Error()))
-			os.Exit(1)
+			l.Error("option error", zap.String("error", err.Error()))
+			return 1
 		}
+	}
 
-		_ = d.SetMeta("option.tag", tag)
-		_ = d.SetMeta("option.source", sourceRe)
-		_ = d.SetMeta("option.start-time", stStr)
-		_ = d.SetMeta("option.end-time", etStr)
-		_ = d.SetMeta("option.duration", duStr)
-		_ = d.SetMeta("option.concurrency", strconv.Itoa(concurrency))
+	st, et, err := parseTimes(stStr, etStr, duStr)
+	if err != nil {
+		l.Error("option error", zap.String("error", err.Error()))
+		return 1
+	}
 
-		targets, err := cfg.FilterTargets(tag, sourceRe)
+	l.Debug(fmt.Sprintf("Client concurrency: %d", concurrency))
+	l.Info(fmt.Sprintf("Log timestamp: %s - %s", st.Format(time.RFC3339), et.Format(time.RFC3339)))
+	l.Debug("Start fetching from targets")
+
+	sts := map[int64]*time.Time{}
+	if appendMode {
+		sts, err = targetStartTimes(d, targets, st)
 		if err != nil {
-			l.Error("tag option error", zap.String("error", err.Error()))
-			os.Exit(1)
+			l.Error("DB error", zap.String("error", err.Error()))
+			return 1
 		}
-		if len(targets) == 0 {
-			l.Error("No targets")
-			os.Exit(1)
+		err = d.LoadDedup(sts)
+		if err != nil {
+			l.Error("DB error", zap.String("error", err.Error()))
+			return 1
 		}
-		l.Info(fmt.Sprintf("Target count: %d", len(targets)))
+	}
+	fps := map[int64][]db.FilePosition{}
+	if sinceLast {
+		fps, err = d.GetFilePositions()
+		if err != nil {
+			l.Error("DB error", zap.String("error", err.Error()))
+			return 1
+		}
+	}
+
+	_ = d.SetMeta("fetch.started_at", time.Now().Format(time.RFC3339))
 
-		_ = d.SetMeta("fetch.target-count", strconv.Itoa(len(targets)))
+	go d.StartInsert()
 
-		if presetSSHKeyPassphrase {
-			err = presetSSHKeyPassphraseToTargets(targets)
-			if err != nil {
-				l.Error("option error", zap.String("error", err.Error()))
-				os.Exit(1)
+	pool := client.NewSSHPool()
+	defer pool.Close()
+
+	results := fetchTargets(l, targets, concurrency, func(t *config.Target) db.FetchResult {
+		tst := st
+		if s, ok := sts[t.Id]; ok {
+			tst = s
+		}
+		opts := []collector.Option{collector.SSHPool(pool)}
+		if sinceLast {
+			opts = append(opts, collector.FileOffsets(fileOffsets(fps[t.Id])))
+		}
+		return fetchTarget(ctx, l, t, func(ctx context.Context, timeout time.Duration) (fetchStats, error) {
+			c, err := fetchTargetOnce(ctx, l, d, t, tst, et, timeout, opts...)
+			if c == nil {
+				return nil, err
 			}
+			return c, err
+		}, retryBackoff)
+	})
+	d.StopInsert()
+
+	// targets whose logs are not stored are failed, so that their positions are not saved
+	insertErrs := d.InsertErrors()
+	for i, r := range results {
+		err, ok := insertErrs[r.TargetId]
+		if !ok {
+			continue
+		}
+		results[i].Status = fetchStatusFailed
+		if r.Error != "" {
+			results[i].Error = fmt.Sprintf("%s, %s", r.Error, err)
+			continue
 		}
+		results[i].Error = err.Error()
+	}
 
-		st, et, err := parseTimes(stStr, etStr, duStr)
+	failed := map[int64]bool{}
+	for _, r := range results {
+		if r.Status != fetchStatusOK {
+			failed[r.TargetId] = true
+		}
+		err := d.SetFetchResult(r)
 		if err != nil {
-			l.Error("option error", zap.String("error", err.Error()))
-			os.Exit(1)
-		}
-
-		l.Debug(fmt.Sprintf("Client concurrency: %d", concurrency))
-		l.Info(fmt.Sprintf("Log timestamp: %s - %s", st.Format(time.RFC3339), et.Format(time.RFC3339)))
-		l.Debug("Start fetching from targets")
-
-		_ = d.SetMeta("fetch.started_at", time.Now().Format(time.RFC3339))
-
-		go d.StartInsert()
-
-		cChan := make(chan struct{}, concurrency)
-		var wg sync.WaitGroup
-
-		finished := 0
-		for _, t := range targets {
-			wg.Add(1)
-			go func(t *config.Target) {
-				cChan <- struct{}{}
-				defer func() {
-					finished = finished + 1
-					l.Info(fmt.Sprintf("Fetching progress: %d/%d", finished, len(targets)))
-					wg.Done()
-				}()
-				c, err := collector.NewCollector(ctx, t, l)
-				if err != nil {
-					l.Error("Fetch error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
-					return
-				}
-				err = c.Fetch(d.In(), st, et, t.MultiLine)
-				if err != nil {
-					l.Error("Fetch error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
-				}
+			l.Error("DB error", zap.String("error", err.Error()))
+		}
+	}
+	err = d.SavePositions(targets, et, failed)
+	if err != nil {
+		l.Error("DB error", zap.String("error", err.Error()))
+	}
+
+	_ = d.SetMeta("fetch.finished_at", time.Now().Format(time.RFC3339))
+	_ = d.SetMeta("fetch.failure-count", strconv.Itoa(len(failed)))
+
+	printFetchResults(os.Stdout, results)
+
+	if len(failed) > 0 {
+		l.Error(fmt.Sprintf("Fetch finished with failures: %d/%d", len(failed), len(targets)))
+		for i, r := range results {
+			if r.Status != fetchStatusOK {
+				l.Error("Failed target", zap.String("host", targets[i].Host), zap.String("path", targets[i].Path), zap.String("status", r.Status), zap.String("error", r.Error))
+			}
+		}
+		return 1
+	}
+
+	l.Info("Fetch finished")
+	return 0
+}
+
+const (
+	fetchStatusOK      = "ok"
+	fetchStatusFailed  = "failed"
+	fetchStatusTimeout = "timeout"
+)
+
+// fetchTimeoutError is returned when fetching from the target does not finish within the timeout.
+// It is not retried, so that a hung target does not hold the slot of the concurrency for the timeout of every attempt.
+type fetchTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *fetchTimeoutError) Error() string {
+	return fmt.Sprintf("timeout (%s)", e.timeout)
+}
+
+// fetchTargets fetches logs from the targets by fetch with the concurrency, and returns the results in the order of the targets
+func fetchTargets(l *zap.Logger, targets []*config.Target, concurrency int, fetch func(t *config.Target) db.FetchResult) []db.FetchResult {
+	cChan := make(chan struct{}, concurrency)
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	results := make([]db.FetchResult, len(targets))
+	finished := 0
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *config.Target) {
+			cChan <- struct{}{}
+			defer func() {
 				<-cChan
-			}(t)
+				mu.Lock()
+				finished = finished + 1
+				l.Info(fmt.Sprintf("Fetching progress: %d/%d", finished, len(targets)))
+				mu.Unlock()
+				wg.Done()
+			}()
+			results[i] = fetch(t)
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+// fetchStats is the number and the bytes of the logs fetched by an attempt ( *collector.Collector )
+type fetchStats interface {
+	Fetched() (int64, int64)
+	Transferred() (int64, int64, bool)
+	Note() string
+}
+
+// fetchTarget fetches logs from the target by fetchOnce within the timeout.
+// Transient errors are retried with backoff while no logs of the target have been fetched, so that retries do not duplicate logs.
+func fetchTarget(ctx context.Context, l *zap.Logger, t *config.Target, fetchOnce func(ctx context.Context, timeout time.Duration) (fetchStats, error), backoff func(attempt int) time.Duration) db.FetchResult {
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = fetchTimeout
+	}
+	r := db.FetchResult{
+		TargetId: t.Id,
+		Source:   t.Source,
+	}
+	started := time.Now()
+	var err error
+L:
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			wait := backoff(attempt)
+			l.Warn(fmt.Sprintf("Retry fetching in %s (%d/%d)", wait, attempt, fetchRetry), zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
+			select {
+			case <-ctx.Done():
+				// the error of the last attempt is reported
+				break L
+			case <-time.After(wait):
+			}
+		}
+		r.Attempts = attempt + 1
+		var c fetchStats
+		c, err = fetchOnce(ctx, timeout)
+		var lines int64
+		if c != nil {
+			var bytes int64
+			lines, bytes = c.Fetched()
+			r.Lines += lines
+			r.Bytes += bytes
+			if wire, decoded, ok := c.Transferred(); ok {
+				r.WireBytes = addInt64(r.WireBytes, wire)
+				r.DecodedBytes = addInt64(r.DecodedBytes, decoded)
+			}
+			r.Note = c.Note()
 		}
+		if !retryable(ctx, t, err, attempt, lines) {
+			break
+		}
+	}
+	r.DurationMs = int64(time.Since(started) / time.Millisecond)
+	r.FetchedAt = time.Now().Format(time.RFC3339)
+	switch err.(type) {
+	case nil:
+		r.Status = fetchStatusOK
+	case *fetchTimeoutError:
+		r.Status = fetchStatusTimeout
+	default:
+		r.Status = fetchStatusFailed
+	}
+	if err != nil {
+		r.Error = err.Error()
+		l.Error("Fetch error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
+	}
+	return r
+}
 
-		wg.Wait()
+// retryable reports whether the attempt of fetching from the target is retried
+func retryable(ctx context.Context, t *config.Target, err error, attempt int, lines int64) bool {
+	if err == nil || lines > 0 || attempt >= fetchRetry || ctx.Err() != nil {
+		return false
+	}
+	// logs from stdin can not be read again
+	if t.Scheme == "stdin" {
+		return false
+	}
+	if _, ok := err.(*fetchTimeoutError); ok {
+		return false
+	}
+	return client.IsTransient(err)
+}
 
-		l.Info("Fetch finished")
-		_ = d.SetMeta("fetch.finished_at", time.Now().Format(time.RFC3339))
-	},
+// fetchTargetOnce fetches logs from the target, and returns the collector to get the number and the bytes of the fetched logs ( nil if it is not created ).
+// The offsets of the files read by the collector are set to the DB on success.
+func fetchTargetOnce(ctx context.Context, l *zap.Logger, d *db.DB, t *config.Target, st, et *time.Time, timeout time.Duration, opts ...collector.Option) (*collector.Collector, error) {
+	tctx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		tctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	c, err := collector.NewCollector(tctx, t, l, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Fetch(d.In(), st, et, t.MultiLine)
+	if tctx.Err() == context.DeadlineExceeded {
+		err = &fetchTimeoutError{timeout: timeout}
+	}
+	if err == nil {
+		if offsets, ok := c.Offsets(); ok {
+			d.SetFilePositions(t.Id, filePositions(t.Id, offsets))
+		}
+	}
+	return c, err
+}
+
+// fileOffsets converts the positions of the files in the DB to the offsets to resume reading from
+func fileOffsets(positions []db.FilePosition) []client.FileOffset {
+	offsets := []client.FileOffset{}
+	for _, p := range positions {
+		offsets = append(offsets, client.FileOffset{Path: p.Path, Inode: p.Inode, Head: p.Head, Offset: p.Offset})
+	}
+	return offsets
+}
+
+// filePositions converts the offsets of the files read from the target to the positions in the DB
+func filePositions(targetId int64, offsets []client.FileOffset) []db.FilePosition {
+	positions := []db.FilePosition{}
+	for _, o := range offsets {
+		positions = append(positions, db.FilePosition{TargetId: targetId, Path: o.Path, Inode: o.Inode, Head: o.Head, Offset: o.Offset})
+	}
+	return positions
+}
+
+func addInt64(p *int64, n int64) *int64 {
+	if p != nil {
+		n = n + *p
+	}
+	return &n
+}
+
+// retryBackoff returns the wait before the attempt ( 1s, 2s, 4s, ... up to 30s )
+func retryBackoff(attempt int) time.Duration {
+	wait := time.Second
+	for i := 1; i < attempt && wait < maxRetryBackoff; i++ {
+		wait = wait * 2
+	}
+	if wait > maxRetryBackoff {
+		wait = maxRetryBackoff
+	}
+	return wait
+}
+
+// printFetchResults prints the results of the fetch as a table
+func printFetchResults(w io.Writer, results []db.FetchResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "TARGET\tLINES\tBYTES\tWIRE\tDECODED\tDURATION\tATTEMPTS\tSTATUS\tNOTE\tERROR")
+	for _, r := range results {
+		note := r.Note
+		if note == "" {
+			note = "-"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", r.Source, r.Lines, r.Bytes, formatNullInt64(r.WireBytes), formatNullInt64(r.DecodedBytes), time.Duration(r.DurationMs)*time.Millisecond, r.Attempts, r.Status, note, strings.Replace(r.Error, "\n", " ", -1))
+	}
+	_ = tw.Flush()
+}
+
+func formatNullInt64(p *int64) string {
+	if p == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*p, 10)
+}
+
+// targetStartTimes returns the start time of each target.
+// With --since-last, logs are fetched from the last position of the target ( the timestamp of the last log or the end time of the last fetch ).
+func targetStartTimes(d *db.DB, targets []*config.Target, st *time.Time) (map[int64]*time.Time, error) {
+	sts := map[int64]*time.Time{}
+	positions, err := d.GetPositions()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		sts[t.Id] = st
+		if !sinceLast {
+			continue
+		}
+		p, ok := positions[t.Id]
+		if !ok {
+			continue
+		}
+		var last time.Time
+		switch {
+		case p.TsUnixNano != nil:
+			// logs with the same timestamp as the last log are fetched again, and skipped as duplicates
+			last = time.Unix(0, *p.TsUnixNano-1)
+		case p.FetchedUntil != nil:
+			last = time.Unix(0, *p.FetchedUntil)
+		default:
+			continue
+		}
+		if stStr == "" || last.After(*st) {
+			sts[t.Id] = &last
+		}
+	}
+	return sts, nil
 }
 
 func init() {
@@ -169,6 +536,18 @@ func init() {
 	fetchCmd.Flags().StringVarP(&stStr, "start-time", "", "", "log start time (format: 2006-01-02 15:04:05)")
 	fetchCmd.Flags().StringVarP(&etStr, "end-time", "", "", "log end time (default: latest) (format: 2006-01-02 15:04:05)")
 	fetchCmd.Flags().StringVarP(&duStr, "duration", "", "", "log duration")
+	fetchCmd.Flags().BoolVarP(&appendDB, "append", "", false, "append logs to the existing db ( logs already in the db are skipped )")
+	fetchCmd.Flags().DurationVarP(&fetchTimeout, "timeout", "", 0, "timeout of fetching from each target ( default: no timeout, overridden by timeout: of the target set )")
+	fetchCmd.Flags().IntVarP(&fetchRetry, "retry", "", defaultFetchRetry, "number of retries on transient errors ( e.g. connection refused )")
+	fetchCmd.Flags().StringVarP(&sshCompression, "ssh-compression", "", "", "compress the output of remote read commands with gzip or zstd ( for ssh:// targets without sshCompression: )")
+	fetchCmd.Flags().StringVarP(&grep, "grep", "", "", "fetch only logs matching the regexp ( multi-line logs are matched as a whole )")
+	fetchCmd.Flags().StringVarP(&grepV, "grep-v", "", "", "fetch only logs not matching the regexp")
+	fetchCmd.Flags().BoolVarP(&sinceLast, "since-last", "", false, "fetch logs since the last fetch of each target ( implies --append )")
 	fetchCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print debugging messages.")
-	fetchCmd.Flags().BoolVarP(&presetSSHKeyPassphrase, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	addStdinFlags(fetchCmd)
+	fetchCmd.Flags().BoolVarP(&presetCredentials, "preset-credentials", "", false, "preset credentials ( SSH key passphrases, SSH passwords and the sudo password for become )")
+	fetchCmd.Flags().BoolVarP(&presetCredentials, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	_ = fetchCmd.Flags().MarkDeprecated("preset-ssh-key-passphrase", "use --preset-credentials instead")
+	fetchCmd.Flags().BoolVarP(&presetCredentials, "preset-become-password", "", false, "preset sudo password for become")
+	_ = fetchCmd.Flags().MarkDeprecated("preset-become-password", "use --preset-credentials instead")
 }
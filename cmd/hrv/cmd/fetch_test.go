@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/harvest/config"
+	"github.com/k1LoW/harvest/db"
+	"go.uber.org/zap"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	var tests = []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(tt.attempt); got != tt.want {
+			t.Errorf("\ngot %v\nwant %v", got, tt.want)
+		}
+	}
+}
+
+// fakeFetchStats is fetchStats of an attempt
+type fakeFetchStats struct {
+	lines int64
+}
+
+func (s *fakeFetchStats) Fetched() (int64, int64) {
+	return s.lines, s.lines * 10
+}
+
+func (s *fakeFetchStats) Transferred() (int64, int64, bool) {
+	return 0, 0, false
+}
+
+func (s *fakeFetchStats) Note() string {
+	return ""
+}
+
+type fakeAttempt struct {
+	lines int64
+	err   error
+}
+
+func TestFetchTarget(t *testing.T) {
+	transient := errors.New("dial tcp 127.0.0.1:22: connect: connection refused")
+	permanent := errors.New("permission denied")
+	timeout := &fetchTimeoutError{timeout: time.Second}
+	var tests = []struct {
+		scheme       string
+		attempts     []fakeAttempt
+		canceled     bool
+		wantAttempts int
+		wantLines    int64
+		wantStatus   string
+	}{
+		{"ssh", []fakeAttempt{{2, nil}}, false, 1, 2, fetchStatusOK},
+		{"ssh", []fakeAttempt{{0, transient}, {2, nil}}, false, 2, 2, fetchStatusOK},
+		{"ssh", []fakeAttempt{{0, transient}, {0, transient}, {0, transient}, {2, nil}}, false, 3, 0, fetchStatusFailed},
+		{"ssh", []fakeAttempt{{1, transient}, {2, nil}}, false, 1, 1, fetchStatusFailed},
+		{"ssh", []fakeAttempt{{0, permanent}, {2, nil}}, false, 1, 0, fetchStatusFailed},
+		{"ssh", []fakeAttempt{{0, timeout}, {2, nil}}, false, 1, 0, fetchStatusTimeout},
+		{"stdin", []fakeAttempt{{0, transient}, {2, nil}}, false, 1, 0, fetchStatusFailed},
+		{"ssh", []fakeAttempt{{0, transient}, {2, nil}}, true, 1, 0, fetchStatusFailed},
+	}
+	fetchRetry = 2
+	defer func() {
+		fetchRetry = defaultFetchRetry
+	}()
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.canceled {
+			cancel()
+		}
+		target := &config.Target{Id: 1, Source: fmt.Sprintf("%s://host/var/log/app.log", tt.scheme), Scheme: tt.scheme}
+		i := 0
+		got := fetchTarget(ctx, zap.NewNop(), target, func(ctx context.Context, timeout time.Duration) (fetchStats, error) {
+			a := tt.attempts[i]
+			i++
+			return &fakeFetchStats{lines: a.lines}, a.err
+		}, func(attempt int) time.Duration {
+			return time.Millisecond
+		})
+		cancel()
+		if got.Attempts != tt.wantAttempts || got.Lines != tt.wantLines || got.Status != tt.wantStatus {
+			t.Errorf("\ngot %v %v %v\nwant %v %v %v", got.Attempts, got.Lines, got.Status, tt.wantAttempts, tt.wantLines, tt.wantStatus)
+		}
+	}
+}
+
+func TestFetchTargetCanceledInBackoff(t *testing.T) {
+	transient := errors.New("dial tcp 127.0.0.1:22: connect: connection refused")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := &config.Target{Id: 1, Source: "ssh://host/var/log/app.log", Scheme: "ssh"}
+	attempts := 0
+	got := fetchTarget(ctx, zap.NewNop(), target, func(ctx context.Context, timeout time.Duration) (fetchStats, error) {
+		attempts++
+		return &fakeFetchStats{}, transient
+	}, func(attempt int) time.Duration {
+		// canceled while waiting for the retry
+		cancel()
+		return time.Hour
+	})
+	if attempts != 1 || got.Attempts != 1 || got.Status != fetchStatusFailed || got.Error != transient.Error() {
+		t.Errorf("\ngot %v %v %v %v\nwant 1 1 %v %v", attempts, got.Attempts, got.Status, got.Error, fetchStatusFailed, transient)
+	}
+}
+
+func TestFetchTargets(t *testing.T) {
+	targets := []*config.Target{}
+	for i := 1; i <= 6; i++ {
+		targets = append(targets, &config.Target{Id: int64(i)})
+	}
+	var (
+		mu      sync.Mutex
+		running int
+		max     int
+	)
+	done := make(chan []db.FetchResult)
+	go func() {
+		done <- fetchTargets(zap.NewNop(), targets, 2, func(t *config.Target) db.FetchResult {
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			status := fetchStatusOK
+			if t.Id%2 == 0 {
+				status = fetchStatusFailed
+			}
+			return db.FetchResult{TargetId: t.Id, Status: status}
+		})
+	}()
+	var results []db.FetchResult
+	select {
+	case results = <-done:
+	case <-time.After(10 * time.Second):
+		// the slots of the concurrency are not released
+		t.Fatal("timeout")
+	}
+	if max != 2 {
+		t.Errorf("\ngot %v\nwant %v", max, 2)
+	}
+	for i, r := range results {
+		if r.TargetId != targets[i].Id {
+			t.Errorf("\ngot %v\nwant %v", r.TargetId, targets[i].Id)
+		}
+	}
+}
@@ -64,6 +64,16 @@ var infoCmd = &cobra.Command{
 		for _, m := range metas {
 			fmt.Printf("%s=%s\n", m.Key, m.Value)
 		}
+
+		results, err := d.GetFetchResults()
+		if err != nil {
+			l.Error("info error", zap.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if len(results) > 0 {
+			fmt.Println("")
+			printFetchResults(os.Stdout, results)
+		}
 	},
 }
 
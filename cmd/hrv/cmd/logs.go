@@ -26,6 +26,7 @@ import (
 	"os"
 	"sync"
 
+	"github.com/k1LoW/harvest/client"
 	"github.com/k1LoW/harvest/collector"
 	"github.com/k1LoW/harvest/config"
 	"github.com/k1LoW/harvest/logger"
@@ -66,8 +67,8 @@ var logsCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		if presetSSHKeyPassphrase {
-			err = presetSSHKeyPassphraseToTargets(targets)
+		if presetCredentials {
+			err = presetCredentialsToTargets(targets)
 			if err != nil {
 				l.Error("option error", zap.String("error", err.Error()))
 				os.Exit(1)
@@ -95,6 +96,8 @@ var logsCmd = &cobra.Command{
 			}
 		}()
 
+		pool := client.NewSSHPool()
+		defer pool.Close()
 		cChan := make(chan struct{}, concurrency)
 		var wg sync.WaitGroup
 
@@ -102,16 +105,19 @@ var logsCmd = &cobra.Command{
 			wg.Add(1)
 			go func(t *config.Target) {
 				cChan <- struct{}{}
-				defer wg.Done()
-				c, err := collector.NewCollector(ctx, t, l)
+				defer func() {
+					<-cChan
+					wg.Done()
+				}()
+				c, err := collector.NewCollector(ctx, t, l, collector.SSHPool(pool))
 				if err != nil {
 					l.Error("Ls error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
+					return
 				}
 				err = c.LsLogs(logChan, st, et)
 				if err != nil {
 					l.Error("Ls error", zap.String("host", t.Host), zap.String("path", t.Path), zap.String("error", err.Error()))
 				}
-				<-cChan
 			}(t)
 		}
 
@@ -131,6 +137,10 @@ func init() {
 	logsCmd.Flags().StringVarP(&stStr, "start-time", "", "", "log start time (format: 2006-01-02 15:04:05)")
 	logsCmd.Flags().StringVarP(&etStr, "end-time", "", "", "log end time (default: latest) (format: 2006-01-02 15:04:05)")
 	logsCmd.Flags().StringVarP(&duStr, "duration", "", "", "log duration")
-	logsCmd.Flags().BoolVarP(&presetSSHKeyPassphrase, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	logsCmd.Flags().BoolVarP(&presetCredentials, "preset-credentials", "", false, "preset credentials ( SSH key passphrases, SSH passwords and the sudo password for become )")
+	logsCmd.Flags().BoolVarP(&presetCredentials, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	_ = logsCmd.Flags().MarkDeprecated("preset-ssh-key-passphrase", "use --preset-credentials instead")
+	logsCmd.Flags().BoolVarP(&presetCredentials, "preset-become-password", "", false, "preset sudo password for become")
+	_ = logsCmd.Flags().MarkDeprecated("preset-become-password", "use --preset-credentials instead")
 	logsCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print debugging messages.")
 }
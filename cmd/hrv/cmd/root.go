@@ -25,9 +25,9 @@ import (
 	"os"
 	"time"
 
-	"github.com/Songmu/prompter"
 	"github.com/araddon/dateparse"
 	"github.com/k1LoW/duration"
+	"github.com/k1LoW/harvest/client"
 	"github.com/k1LoW/harvest/config"
 	"github.com/spf13/cobra"
 )
@@ -37,18 +37,19 @@ const (
 )
 
 var (
-	tag                    string
-	configPath             string
-	sourceRe               string
-	withTimestamp          bool
-	withTimestampNano      bool
-	withHost               bool
-	withPath               bool
-	withTag                bool
-	withoutMark            bool
-	noColor                bool
-	presetSSHKeyPassphrase bool
-	verbose                bool
+	tag               string
+	configPath        string
+	sourceRe          string
+	withTimestamp     bool
+	withTimestampNano bool
+	withHost          bool
+	withPath          bool
+	withTag           bool
+	withFields        bool
+	withoutMark       bool
+	noColor           bool
+	presetCredentials bool
+	verbose           bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -67,41 +68,78 @@ func Execute() {
 
 func init() {}
 
-type hostPassphrase struct {
-	host       string
-	passphrase []byte
+// presetCredentialsToTargets asks the credentials ( passphrases of encrypted identity files, SSH passwords and the sudo password for become ) before connecting.
+// Each credential is asked once, and the answers are shared by the targets.
+func presetCredentialsToTargets(targets []*config.Target) error {
+	for i, target := range targets {
+		if !usesSSH(target) {
+			continue
+		}
+		keyPath, encrypted, err := client.IdentityFileNeedsPassphrase(target.Host, target.User, target.SSHIdentityFile)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			passphrase, err := client.PromptCredential(client.PassphraseKey(keyPath), fmt.Sprintf("Enter passphrase for key '%s'", keyPath))
+			if err != nil {
+				return err
+			}
+			targets[i].SSHKeyPassphrase = passphrase
+		}
+		if target.SSHPasswordAuth {
+			dest, err := client.SSHDest(target.Host, target.User, target.Port)
+			if err != nil {
+				return err
+			}
+			password, err := client.PromptCredential(client.PasswordKey(dest), fmt.Sprintf("Enter password for '%s'", dest))
+			if err != nil {
+				return err
+			}
+			targets[i].SSHPassword = password
+		}
+	}
+	return presetBecomePasswordToTargets(targets)
 }
 
-func presetSSHKeyPassphraseToTargets(targets []*config.Target) error {
-	hpMap := map[string]hostPassphrase{}
-	var defaultPassohrase []byte
-
-	yn := prompter.YN("Do you preset default passphrase for all targets?", true)
-	if yn {
-		fmt.Println("Preset default passphrase")
-		defaultPassohrase = []byte(prompter.Password("Enter default passphrase"))
-	} else {
-		fmt.Println("Preset passphrase for each target")
+// usesSSH returns whether the target is read via SSH
+func usesSSH(t *config.Target) bool {
+	switch t.Scheme {
+	case "ssh", "ssh+exec":
+		return true
+	case "docker", "journal":
+		return t.Host != "localhost"
+	default:
+		return false
 	}
+}
 
+// presetBecomePasswordToTargets asks the sudo password once, and sets it to the targets reading logs with sudo
+func presetBecomePasswordToTargets(targets []*config.Target) error {
+	var password []byte
 	for i, target := range targets {
-		if target.Scheme != "ssh" {
-			continue
-		}
-		if yn {
-			targets[i].SSHKeyPassphrase = defaultPassohrase
+		switch target.Scheme {
+		case "ssh", "file", "journal":
+			if target.Become != "" && target.Become != "sudo" {
+				continue
+			}
+		case "exec", "ssh+exec":
+			if target.Become != "sudo" {
+				continue
+			}
+		default:
 			continue
 		}
-		if hp, ok := hpMap[target.Host]; ok {
-			targets[i].SSHKeyPassphrase = hp.passphrase
+		if target.SSHMode == "sftp" {
 			continue
 		}
-		passphrase := []byte(prompter.Password(fmt.Sprintf("Enter passphrase for host '%s'", target.Host)))
-		targets[i].SSHKeyPassphrase = passphrase
-		hpMap[target.Host] = hostPassphrase{
-			host:       target.Host,
-			passphrase: passphrase,
+		if password == nil {
+			p, err := client.PromptCredential("become", "Enter sudo password ( empty if not required )")
+			if err != nil {
+				return err
+			}
+			password = p
 		}
+		targets[i].BecomePassword = password
 	}
 	return nil
 }
@@ -0,0 +1,107 @@
+// Copyright © 2019 Ken'ichiro Oyama <k1lowxb@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/k1LoW/harvest/config"
+	"github.com/spf13/cobra"
+)
+
+const stdinTag = "stdin"
+
+var (
+	stdinType       string
+	stdinRegexp     string
+	stdinTimeFormat string
+	stdinTimeZone   string
+	stdinMultiLine  bool
+)
+
+// stdinArgs validates the stdin source ( stdin:// or - ) given as the argument
+func stdinArgs(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return errors.New("accepts at most one stdin source")
+	}
+	for _, src := range args {
+		if src != "-" && !strings.HasPrefix(src, "stdin://") {
+			return fmt.Errorf("invalid stdin source: %s", src)
+		}
+	}
+	return nil
+}
+
+// loadConfig loads the config file, and adds the target set of the stdin source if it is given as the argument.
+// The target of the stdin source is returned with the config ( or nil ).
+func loadConfig(args []string) (*config.Config, *config.Target, error) {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if configPath != "" || len(args) == 0 {
+		err = cfg.LoadConfigFile(configPath)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(args) == 0 {
+		return cfg, nil, nil
+	}
+	err = cfg.AddTargetSet(&config.TargetSet{
+		Sources:     args,
+		Description: "stdin",
+		Type:        stdinType,
+		Regexp:      stdinRegexp,
+		MultiLine:   stdinMultiLine,
+		TimeFormat:  stdinTimeFormat,
+		TimeZone:    stdinTimeZone,
+		Tags:        []string{stdinTag},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, cfg.Targets[len(cfg.Targets)-1], nil
+}
+
+// withStdinTarget returns targets including the target of the stdin source given as the argument, which is not filtered by --tag and --source.
+// stdin targets of the config file are filtered as usual.
+func withStdinTarget(targets []*config.Target, stdinTarget *config.Target) []*config.Target {
+	if stdinTarget == nil {
+		return targets
+	}
+	for _, t := range targets {
+		if t == stdinTarget {
+			return targets
+		}
+	}
+	return append(targets, stdinTarget)
+}
+
+func addStdinFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&stdinType, "type", "", "none", "log type of the stdin source")
+	cmd.Flags().StringVarP(&stdinRegexp, "regexp", "", "", "regexp of the stdin source ( for --type regexp )")
+	cmd.Flags().StringVarP(&stdinTimeFormat, "time-format", "", "", "time format of the stdin source ( for --type regexp )")
+	cmd.Flags().StringVarP(&stdinTimeZone, "time-zone", "", "", "time zone of the stdin source")
+	cmd.Flags().BoolVarP(&stdinMultiLine, "multi-line", "", false, "parse the stdin source as multi-line logs")
+}
@@ -39,18 +39,14 @@ import (
 
 // streamCmd represents the stream command
 var streamCmd = &cobra.Command{
-	Use:   "stream",
+	Use:   "stream [stdin:// | -]",
 	Short: "output stream from targets",
 	Long:  `output stream from targets.`,
+	Args:  stdinArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		l := logger.NewLogger(verbose)
 
-		cfg, err := config.NewConfig()
-		if err != nil {
-			l.Error("Config error", zap.String("error", err.Error()))
-			os.Exit(1)
-		}
-		err = cfg.LoadConfigFile(configPath)
+		cfg, stdinTarget, err := loadConfig(args)
 		if err != nil {
 			l.Error("Config error", zap.String("error", err.Error()))
 			os.Exit(1)
@@ -64,14 +60,15 @@ var streamCmd = &cobra.Command{
 			l.Error("tag option error", zap.String("error", err.Error()))
 			os.Exit(1)
 		}
+		targets = withStdinTarget(targets, stdinTarget)
 		if len(targets) == 0 {
 			l.Error("No targets")
 			os.Exit(1)
 		}
 		l.Info(fmt.Sprintf("Target count: %d", len(targets)))
 
-		if presetSSHKeyPassphrase {
-			err = presetSSHKeyPassphraseToTargets(targets)
+		if presetCredentials {
+			err = presetCredentialsToTargets(targets)
 			if err != nil {
 				l.Error("option error", zap.String("error", err.Error()))
 				os.Exit(1)
@@ -90,6 +87,7 @@ var streamCmd = &cobra.Command{
 			withHost,
 			withPath,
 			withTag,
+			withFields,
 			withoutMark,
 			hLen,
 			tLen,
@@ -207,10 +205,16 @@ func init() {
 	streamCmd.Flags().BoolVarP(&withHost, "with-host", "", false, "output with host")
 	streamCmd.Flags().BoolVarP(&withPath, "with-path", "", false, "output with path")
 	streamCmd.Flags().BoolVarP(&withTag, "with-tag", "", false, "output with tag")
+	streamCmd.Flags().BoolVarP(&withFields, "with-fields", "", false, "output with structured fields ( e.g. priority of journald )")
 	streamCmd.Flags().BoolVarP(&withoutMark, "without-mark", "", false, "output without prefix mark")
 	streamCmd.Flags().StringVarP(&tag, "tag", "", "", "filter targets using tag (format: foo,bar)")
 	streamCmd.Flags().StringVarP(&sourceRe, "source", "", "", "filter targets using source regexp")
 	streamCmd.Flags().BoolVarP(&noColor, "no-color", "", false, "disable colorize output")
-	streamCmd.Flags().BoolVarP(&presetSSHKeyPassphrase, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	streamCmd.Flags().BoolVarP(&presetCredentials, "preset-credentials", "", false, "preset credentials ( SSH key passphrases, SSH passwords and the sudo password for become )")
+	streamCmd.Flags().BoolVarP(&presetCredentials, "preset-ssh-key-passphrase", "", false, "preset SSH key passphrase")
+	_ = streamCmd.Flags().MarkDeprecated("preset-ssh-key-passphrase", "use --preset-credentials instead")
+	streamCmd.Flags().BoolVarP(&presetCredentials, "preset-become-password", "", false, "preset sudo password for become")
+	_ = streamCmd.Flags().MarkDeprecated("preset-become-password", "use --preset-credentials instead")
 	streamCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print debugging messages.")
+	addStdinFlags(streamCmd)
 }
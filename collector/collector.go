@@ -3,6 +3,7 @@ package collector
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/k1LoW/harvest/client"
@@ -13,15 +14,44 @@ import (
 
 // Collector ...
 type Collector struct {
-	client client.Client
-	parser parser.Parser
-	target *config.Target
-	ctx    context.Context
-	logger *zap.Logger
+	client  client.Client
+	parser  parser.Parser
+	filter  *client.Filter
+	target  *config.Target
+	sshPool *client.SSHPool
+	offsets []client.FileOffset
+	resume  bool
+	ctx     context.Context
+	logger  *zap.Logger
+
+	mu      sync.Mutex
+	stopped bool
+	lines   int64
+	bytes   int64
+}
+
+// Option ...
+type Option func(*Collector) error
+
+// SSHPool share SSH connections using the pool
+func SSHPool(p *client.SSHPool) Option {
+	return func(c *Collector) error {
+		c.sshPool = p
+		return nil
+	}
+}
+
+// FileOffsets resume reading files of ssh and file targets from the offsets of the last fetch, and record the offsets of the fetch ( see Offsets )
+func FileOffsets(offsets []client.FileOffset) Option {
+	return func(c *Collector) error {
+		c.offsets = offsets
+		c.resume = true
+		return nil
+	}
 }
 
 // NewCollector ...
-func NewCollector(ctx context.Context, t *config.Target, l *zap.Logger) (*Collector, error) {
+func NewCollector(ctx context.Context, t *config.Target, l *zap.Logger, opts ...Option) (*Collector, error) {
 	var (
 		err error
 		c   client.Client
@@ -30,66 +60,240 @@ func NewCollector(ctx context.Context, t *config.Target, l *zap.Logger) (*Collec
 
 	l = l.With(zap.String("host", t.Host), zap.String("path", t.Path))
 
+	collector := &Collector{
+		target: t,
+		ctx:    ctx,
+		logger: l,
+	}
+	for _, opt := range opts {
+		err := opt(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	collector.filter, err = client.NewFilter(t.Grep, t.GrepV)
+	if err != nil {
+		return nil, err
+	}
+	// lines of multi-line logs are filtered after parsing, so that records are kept intact
+	var pushdown *client.Filter
+	if !t.MultiLine {
+		pushdown = collector.filter
+	}
+
+	// Set parser
+	switch t.Type {
+	case "syslog":
+		p, err = parser.NewSyslogParser(t, l)
+		if err != nil {
+			return nil, err
+		}
+	case "combinedLog":
+		p, err = parser.NewCombinedLogParser(t, l)
+		if err != nil {
+			return nil, err
+		}
+	case "none", "k8s", "k8s-events", "docker", "journal":
+		p, err = parser.NewNoneParser(t, l)
+		if err != nil {
+			return nil, err
+		}
+	default: // regexp
+		p, err = parser.NewRegexpParser(t, l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// timestamps of lines are used to seek in large files, so the parser ( setting the regexp and the time format ) is created first
+	var seek client.TimestampFunc
+	if t.Seek && (t.Scheme == "ssh" || t.Scheme == "file") {
+		seek, err = parser.NewTimestampFunc(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the offsets to resume from are found by timestamps of lines, so they are not recorded for targets without regexp and timeFormat
+	var resume client.TimestampFunc
+	if collector.resume && (t.Scheme == "ssh" || t.Scheme == "file") {
+		resume, err = parser.NewTimestampFunc(t)
+		if err != nil {
+			l.Debug("Offsets of files are not recorded", zap.String("error", err.Error()))
+			resume = nil
+		}
+	}
+
+	// continuation lines of multi-line logs are kept with the record by the time filter of the remote read command
+	head := ""
+	if t.MultiLine {
+		head = t.Regexp
+	}
+
 	// Set client
 	switch t.Scheme {
 	case "ssh":
-		sshc, err := client.NewSSHClient(l, t.Host, t.User, t.Port, t.Path, t.SSHKeyPassphrase)
+		become, err := client.NewBecome(t.Become, t.BecomeUser, t.BecomePassword)
+		if err != nil {
+			return nil, err
+		}
+		sshOpts := append(collector.sshOptions(become), client.ReadFilter(pushdown), client.Compression(t.SSHCompression), client.Seek(seek), client.RecordHead(head))
+		switch t.SSHMode {
+		case "", "exec":
+			if resume != nil {
+				sshOpts = append(sshOpts, client.Resume(collector.offsets, resume))
+			}
+		case "sftp":
+			sshOpts = append(sshOpts, client.UseSFTP(true))
+		default:
+			return nil, fmt.Errorf("unsupport sshMode: %s", t.SSHMode)
+		}
+		sshc, err := client.NewSSHClient(l, t.Host, t.User, t.Port, t.Path, t.SSHKeyPassphrase, sshOpts...)
 		if err != nil {
 			return nil, err
 		}
 		c = sshc
 	case "file":
-		filec, err := client.NewFileClient(l, t.Path)
+		become, err := client.NewBecome(t.Become, t.BecomeUser, t.BecomePassword)
+		if err != nil {
+			return nil, err
+		}
+		fileOpts := []client.FileOption{client.FileBecomeAs(become), client.FileReadFilter(pushdown), client.FileSeek(seek), client.FileRecordHead(head)}
+		if resume != nil {
+			fileOpts = append(fileOpts, client.FileResume(collector.offsets, resume))
+		}
+		filec, err := client.NewFileClient(l, t.Path, fileOpts...)
 		if err != nil {
 			return nil, err
 		}
 		c = filec
-	case "k8s":
-		k8sc, err := client.NewK8sClient(l, t.Host, t.Path)
+	case "docker":
+		dockerOpts := []client.DockerOption{client.DockerSSHAuth(collector.sshAuth()), client.DockerDialContext(ctx)}
+		if len(t.SSHJumpHosts) > 0 {
+			dockerOpts = append(dockerOpts, client.DockerJumpHosts(t.SSHJumpHosts))
+		}
+		if collector.sshPool != nil {
+			dockerOpts = append(dockerOpts, client.DockerSSHPool(collector.sshPool))
+		}
+		if t.SSHMaxSessions > 0 {
+			dockerOpts = append(dockerOpts, client.DockerMaxSessions(t.SSHMaxSessions))
+		}
+		dockerc, err := client.NewDockerClient(l, t.Host, t.User, t.Port, t.Path, t.SSHKeyPassphrase, dockerOpts...)
 		if err != nil {
 			return nil, err
 		}
-		c = k8sc
-	default:
-		return nil, fmt.Errorf("unsupport scheme: %s", t.Scheme)
-	}
-
-	// Set parser
-	switch t.Type {
-	case "syslog":
-		p, err = parser.NewSyslogParser(t, l)
+		c = dockerc
+	case "journal":
+		ec, err := collector.newExecClient(t.Host != "localhost" || t.User != "" || t.Port != 0, t.Become)
 		if err != nil {
 			return nil, err
 		}
-	case "combinedLog":
-		p, err = parser.NewCombinedLogParser(t, l)
+		journalc, err := client.NewJournalClient(l, t.Path, ec)
 		if err != nil {
 			return nil, err
 		}
-	case "none", "k8s":
-		p, err = parser.NewNoneParser(t, l)
+		c = journalc
+	case "exec", "ssh+exec":
+		// commands are not run with sudo by default
+		method := t.Become
+		if method == "" {
+			method = client.BecomeNone
+		}
+		ec, err := collector.newExecClient(t.Scheme == "ssh+exec", method)
 		if err != nil {
 			return nil, err
 		}
-	default: // regexp
-		p, err = parser.NewRegexpParser(t, l)
+		commandc, err := client.NewCommandClient(l, t.Path, t.Command, t.FollowCommand, ec)
 		if err != nil {
 			return nil, err
 		}
+		c = commandc
+	case "http", "https":
+		httpc, err := client.NewHTTPClient(l, t.Source)
+		if err != nil {
+			return nil, err
+		}
+		c = httpc
+	case "s3":
+		s3c, err := client.NewS3Client(l, t.Host, t.Path, client.S3Endpoint(t.S3Endpoint), client.S3Region(t.S3Region))
+		if err != nil {
+			return nil, err
+		}
+		c = s3c
+	case "stdin":
+		stdinc, err := client.NewStdinClient(l, t.Host, t.Path)
+		if err != nil {
+			return nil, err
+		}
+		c = stdinc
+	case "k8s-events":
+		eventsc, err := client.NewK8sEventsClient(l, t.Host, t.Path)
+		if err != nil {
+			return nil, err
+		}
+		c = eventsc
+	case "k8s":
+		k8sc, err := client.NewK8sClient(l, t.Host, t.Path, client.K8sSelector(t.K8sSelector), client.K8sContainerFilter(t.K8sContainer), client.K8sPrevious(t.K8sPrevious), client.K8sLimitBytes(t.K8sLimitBytes))
+		if err != nil {
+			return nil, err
+		}
+		c = k8sc
+	default:
+		return nil, fmt.Errorf("unsupport scheme: %s", t.Scheme)
 	}
 
-	return &Collector{
-		client: c,
-		parser: p,
-		target: t,
-		ctx:    ctx,
-		logger: l,
-	}, nil
+	collector.client = c
+	collector.parser = p
+
+	return collector, nil
+}
+
+// sshOptions returns options of SSHClient for the target
+func (c *Collector) sshOptions(become *client.Become) []client.SSHOption {
+	opts := []client.SSHOption{client.BecomeAs(become), client.Auth(c.sshAuth()), client.DialContext(c.ctx)}
+	if len(c.target.SSHJumpHosts) > 0 {
+		opts = append(opts, client.JumpHosts(c.target.SSHJumpHosts))
+	}
+	if c.sshPool != nil {
+		opts = append(opts, client.UseSSHPool(c.sshPool))
+	}
+	if c.target.SSHMaxSessions > 0 {
+		opts = append(opts, client.MaxSessions(c.target.SSHMaxSessions))
+	}
+	return opts
+}
+
+// sshAuth returns the settings of SSH authentication of the target
+func (c *Collector) sshAuth() client.SSHAuth {
+	t := c.target
+	return client.SSHAuth{
+		IdentityFile:  t.SSHIdentityFile,
+		Agent:         t.SSHAgent,
+		PasswordAuth:  t.SSHPasswordAuth,
+		Password:      t.SSHPassword,
+		KnownHosts:    t.SSHKnownHosts,
+		HostKeyPolicy: t.SSHHostKeyPolicy,
+	}
+}
+
+// newExecClient returns SSHClient ( remote ) or FileClient ( local ) for executing commands
+func (c *Collector) newExecClient(remote bool, becomeMethod string) (client.Client, error) {
+	t := c.target
+	become, err := client.NewBecome(becomeMethod, t.BecomeUser, t.BecomePassword)
+	if err != nil {
+		return nil, err
+	}
+	if remote {
+		return client.NewSSHClient(c.logger, t.Host, t.User, t.Port, t.Path, t.SSHKeyPassphrase, c.sshOptions(become)...)
+	}
+	return client.NewFileClient(c.logger, t.Path, client.FileBecomeAs(become))
 }
 
 // Fetch ...
 func (c *Collector) Fetch(dbChan chan parser.Log, st *time.Time, et *time.Time, multiLine bool) error {
-	waiter := make(chan struct{})
+	waiter := make(chan struct{}, 1)
+	stop := make(chan struct{})
 	innerCtx, cancel := context.WithCancel(c.ctx)
 	defer cancel()
 
@@ -99,12 +303,29 @@ func (c *Collector) Fetch(dbChan chan parser.Log, st *time.Time, et *time.Time,
 			waiter <- struct{}{}
 		}()
 		for log := range c.parser.Parse(innerCtx, cancel, c.client.Out(), c.target.TimeZone, st, et) {
-			dbChan <- log
+			if !c.filter.Match(log.Content) {
+				continue
+			}
+			c.mu.Lock()
+			if !c.stopped {
+				select {
+				case dbChan <- log:
+					c.lines++
+					c.bytes += int64(len(log.Content))
+				case <-stop:
+				}
+			}
+			c.mu.Unlock()
 		}
 	}()
 
 	err := c.client.Read(innerCtx, st, et, c.target.TimeFormat, c.target.TimeZone)
 	if err != nil {
+		// the client may not close the channel on error, so stop sending logs to dbChan before returning
+		close(stop)
+		c.mu.Lock()
+		c.stopped = true
+		c.mu.Unlock()
 		return err
 	}
 
@@ -112,6 +333,46 @@ func (c *Collector) Fetch(dbChan chan parser.Log, st *time.Time, et *time.Time,
 	return nil
 }
 
+// Transferred returns the bytes transferred from the target and the bytes after decompression ( ok is false if the client does not report them )
+func (c *Collector) Transferred() (int64, int64, bool) {
+	tc, ok := c.client.(interface {
+		Transferred() (int64, int64, bool)
+	})
+	if !ok {
+		return 0, 0, false
+	}
+	return tc.Transferred()
+}
+
+// Note returns the note of the client about the fetch ( e.g. logs truncated by the limit ), or ""
+func (c *Collector) Note() string {
+	nc, ok := c.client.(interface {
+		Note() string
+	})
+	if !ok {
+		return ""
+	}
+	return nc.Note()
+}
+
+// Offsets returns the offsets of the files to resume reading from next time ( ok is false if the client does not record them )
+func (c *Collector) Offsets() ([]client.FileOffset, bool) {
+	oc, ok := c.client.(interface {
+		Offsets() ([]client.FileOffset, bool)
+	})
+	if !ok {
+		return nil, false
+	}
+	return oc.Offsets()
+}
+
+// Fetched returns the number and the bytes of the logs sent by Fetch
+func (c *Collector) Fetched() (int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lines, c.bytes
+}
+
 // Stream ...
 func (c *Collector) Stream(logChan chan parser.Log, multiLine bool) error {
 	waiter := make(chan struct{})
@@ -124,6 +385,9 @@ func (c *Collector) Stream(logChan chan parser.Log, multiLine bool) error {
 			waiter <- struct{}{}
 		}()
 		for log := range c.parser.Parse(innerCtx, cancel, c.client.Out(), c.target.TimeZone, nil, nil) {
+			if !c.filter.Match(log.Content) {
+				continue
+			}
 			logChan <- log
 		}
 	}()
@@ -186,7 +450,7 @@ func (c *Collector) Copy(logChan chan parser.Log, st *time.Time, et *time.Time,
 		for _, file := range files {
 			filePath := file.Content
 			c.logger.Debug(fmt.Sprintf("Start copying %s", filePath), zap.String("host", c.target.Host), zap.String("path", c.target.Path))
-			err := c.client.Copy(innerCtx, filePath, dstDir)
+			err := c.client.Copy(innerCtx, filePath, dstDir, st, et)
 			if err != nil {
 				c.logger.Error("Copy error", zap.String("host", c.target.Host), zap.String("path", c.target.Path), zap.String("error", err.Error()))
 			} else {
@@ -222,11 +486,8 @@ func (c *Collector) ConfigTest(logChan chan parser.Log, multiLine bool) error {
 	}()
 
 	err := c.client.RandomOne(innerCtx)
-	if err != nil {
-		return err
-	}
 
 	<-waiter
 	close(logChan)
-	return nil
+	return err
 }
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/antonmedv/expr"
 	"github.com/k1LoW/harvest/client/k8s"
@@ -17,14 +18,40 @@ import (
 
 // TargetSet ...
 type TargetSet struct {
-	Sources     []string `yaml:"sources"`
-	Description string   `yaml:"description,omitempty"`
-	Type        string   `yaml:"type"`
-	Regexp      string   `yaml:"regexp,omitempty"`
-	MultiLine   bool     `yaml:"multiLine,omitempty"`
-	TimeFormat  string   `yaml:"timeFormat,omitempty"`
-	TimeZone    string   `yaml:"timeZone,omitempty"`
-	Tags        []string `yaml:"tags"`
+	Sources          []string `yaml:"sources"`
+	Description      string   `yaml:"description,omitempty"`
+	Type             string   `yaml:"type"`
+	Regexp           string   `yaml:"regexp,omitempty"`
+	MultiLine        bool     `yaml:"multiLine,omitempty"`
+	TimeFormat       string   `yaml:"timeFormat,omitempty"`
+	TimeZone         string   `yaml:"timeZone,omitempty"`
+	Seek             bool     `yaml:"seek,omitempty"`
+	SSHMode          string   `yaml:"sshMode,omitempty"`
+	SSHJumpHosts     []string `yaml:"sshJumpHosts,omitempty"`
+	SSHMaxSessions   int      `yaml:"sshMaxSessions,omitempty"`
+	SSHCompression   string   `yaml:"sshCompression,omitempty"`
+	SSHIdentityFile  string   `yaml:"sshIdentityFile,omitempty"`
+	SSHAgent         *bool    `yaml:"sshAgent,omitempty"`
+	SSHPasswordAuth  bool     `yaml:"sshPasswordAuth,omitempty"`
+	SSHKnownHosts    string   `yaml:"sshKnownHosts,omitempty"`
+	SSHHostKeyPolicy string   `yaml:"sshHostKeyPolicy,omitempty"`
+	Become           string   `yaml:"become,omitempty"`
+	BecomeUser       string   `yaml:"becomeUser,omitempty"`
+	Command          string   `yaml:"command,omitempty"`
+	FollowCommand    string   `yaml:"followCommand,omitempty"`
+	S3Endpoint       string   `yaml:"s3Endpoint,omitempty"`
+	S3Region         string   `yaml:"s3Region,omitempty"`
+	K8sPrevious      bool     `yaml:"k8sPrevious,omitempty"`
+	K8sLimitBytes    int64    `yaml:"k8sLimitBytes,omitempty"`
+	Timeout          string   `yaml:"timeout,omitempty"`
+	Filter           *Filter  `yaml:"filter,omitempty"`
+	Tags             []string `yaml:"tags"`
+}
+
+// Filter selects logs of the target set by the content ( regexp )
+type Filter struct {
+	Grep  string `yaml:"grep,omitempty"`
+	GrepV string `yaml:"grepV,omitempty"`
 }
 
 // Target ...
@@ -36,13 +63,38 @@ type Target struct {
 	MultiLine        bool   `db:"multi_line"`
 	TimeFormat       string `db:"time_format"`
 	TimeZone         string `db:"time_zone"`
+	Seek             bool
 	Tags             []string
 	Scheme           string `db:"scheme"`
 	Host             string `db:"host"`
 	User             string `db:"user"`
 	Port             int    `db:"port"`
 	Path             string `db:"path"`
+	SSHMode          string
+	SSHJumpHosts     []string
+	SSHMaxSessions   int
+	SSHCompression   string
+	SSHIdentityFile  string
+	SSHAgent         *bool
+	SSHPasswordAuth  bool
+	SSHKnownHosts    string
+	SSHHostKeyPolicy string
 	SSHKeyPassphrase []byte
+	SSHPassword      []byte
+	Become           string
+	BecomeUser       string
+	BecomePassword   []byte
+	Command          string
+	FollowCommand    string
+	S3Endpoint       string
+	S3Region         string
+	K8sPrevious      bool
+	K8sLimitBytes    int64
+	K8sSelector      string
+	K8sContainer     string
+	Timeout          time.Duration
+	Grep             []string
+	GrepV            []string
 	Id               int64 `db:"id"`
 }
 
@@ -53,10 +105,15 @@ func (t *Target) GetHostLength() int {
 func (t *Target) GetPathLength() (int, error) {
 	if t.Scheme == "k8s" {
 		contextName := t.Host
-		splited := strings.Split(t.Path, "/")
-		namespace := splited[1]
-		podFilter := regexp.MustCompile(strings.Replace(strings.Replace(splited[2], ".*", "*", -1), "*", ".*", -1))
-		containers, err := k8s.GetContainers(contextName, namespace, podFilter)
+		namespace, podFilter, err := k8s.ParsePath(t.Path)
+		if err != nil {
+			return 0, err
+		}
+		containerFilter, err := k8s.NewContainerFilter(t.K8sContainer)
+		if err != nil {
+			return 0, err
+		}
+		containers, err := k8s.GetContainers(contextName, namespace, podFilter, t.K8sSelector, containerFilter)
 		if err != nil {
 			return 0, err
 		}
@@ -66,6 +123,10 @@ func (t *Target) GetPathLength() (int, error) {
 				length = len(c)
 			}
 		}
+		if t.K8sPrevious {
+			// for /namespace/pod/container#previous
+			length += len("#previous")
+		}
 		return length, nil
 	}
 	return len(t.Path), nil
@@ -104,43 +165,131 @@ func (c *Config) LoadConfigFile(path string) error {
 	if err != nil {
 		return errors.Wrap(errors.WithStack(err), "failed to load config file")
 	}
-	for _, t := range c.TargetSets {
-		for _, src := range t.Sources {
-			target := Target{}
-			target.Source = src
-			target.Description = t.Description
-			target.Type = t.Type
-			target.Regexp = t.Regexp
-			target.MultiLine = t.MultiLine
-			target.TimeFormat = t.TimeFormat
-			target.TimeZone = t.TimeZone
-			target.Tags = t.Tags
-
-			u, err := url.Parse(src)
-			if err != nil {
-				return err
-			}
-			target.Scheme = u.Scheme
-			target.Path = u.Path
-			target.User = u.User.Username()
-			if strings.Contains(u.Host, ":") {
-				splited := strings.Split(u.Host, ":")
-				target.Host = splited[0]
-				target.Port, _ = strconv.Atoi(splited[1])
-			} else {
-				target.Host = u.Host
-				target.Port = 0
+	targetSets := c.TargetSets
+	c.TargetSets = []*TargetSet{}
+	for _, t := range targetSets {
+		err := c.AddTargetSet(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTargetSet adds the target set and the targets of the sources
+func (c *Config) AddTargetSet(t *TargetSet) error {
+	var timeout time.Duration
+	if t.Timeout != "" {
+		d, err := time.ParseDuration(t.Timeout)
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), "invalid timeout")
+		}
+		timeout = d
+	}
+	if t.SSHMode == "sftp" && ((t.Become != "" && t.Become != "none") || t.BecomeUser != "") {
+		// SFTP mode reads logs as the SSH user
+		return errors.New("become can not be used with sshMode: sftp")
+	}
+	grep := []string{}
+	grepV := []string{}
+	if t.Filter != nil {
+		if t.Filter.Grep != "" {
+			if _, err := regexp.Compile(t.Filter.Grep); err != nil {
+				return errors.Wrap(errors.WithStack(err), "invalid filter")
 			}
-			if target.Host == "" {
-				target.Host = "localhost"
+			grep = append(grep, t.Filter.Grep)
+		}
+		if t.Filter.GrepV != "" {
+			if _, err := regexp.Compile(t.Filter.GrepV); err != nil {
+				return errors.Wrap(errors.WithStack(err), "invalid filter")
 			}
+			grepV = append(grepV, t.Filter.GrepV)
+		}
+	}
+	for _, src := range t.Sources {
+		if src == "-" {
+			src = "stdin://"
+		}
+		target := Target{}
+		target.Source = src
+		target.Description = t.Description
+		target.Type = t.Type
+		target.Regexp = t.Regexp
+		target.MultiLine = t.MultiLine
+		target.TimeFormat = t.TimeFormat
+		target.TimeZone = t.TimeZone
+		target.Seek = t.Seek
+		target.SSHMode = t.SSHMode
+		target.SSHJumpHosts = t.SSHJumpHosts
+		target.SSHMaxSessions = t.SSHMaxSessions
+		target.SSHCompression = t.SSHCompression
+		target.SSHIdentityFile = t.SSHIdentityFile
+		target.SSHAgent = t.SSHAgent
+		target.SSHPasswordAuth = t.SSHPasswordAuth
+		target.SSHKnownHosts = t.SSHKnownHosts
+		target.SSHHostKeyPolicy = t.SSHHostKeyPolicy
+		target.Become = t.Become
+		target.BecomeUser = t.BecomeUser
+		target.Command = t.Command
+		target.FollowCommand = t.FollowCommand
+		target.S3Endpoint = t.S3Endpoint
+		target.S3Region = t.S3Region
+		target.K8sPrevious = t.K8sPrevious
+		target.K8sLimitBytes = t.K8sLimitBytes
+		target.Timeout = timeout
+		target.Grep = append([]string{}, grep...)
+		target.GrepV = append([]string{}, grepV...)
+		target.Tags = t.Tags
 
-			c.Targets = append(c.Targets, &target)
+		u, err := url.Parse(src)
+		if err != nil {
+			return err
+		}
+		target.Scheme = u.Scheme
+		target.Path = u.Path
+		if u.Scheme == "k8s" {
+			// k8s://context/namespace/pod-name*?selector=app=api&container=!istio-proxy
+			target.K8sSelector = u.Query().Get("selector")
+			target.K8sContainer = u.Query().Get("container")
+		}
+		if u.Scheme == "stdin" {
+			// stdin://host/path labels the logs read from stdin
+			if c.hasScheme("stdin") {
+				return errors.New("stdin can be used as only one source")
+			}
+			if target.Path == "" {
+				target.Path = "-"
+			}
+		}
+		target.User = u.User.Username()
+		if strings.Contains(u.Host, ":") {
+			splited := strings.Split(u.Host, ":")
+			target.Host = splited[0]
+			target.Port, _ = strconv.Atoi(splited[1])
+		} else {
+			target.Host = u.Host
+			target.Port = 0
+		}
+		if target.Host == "" {
+			target.Host = "localhost"
 		}
+
+		c.Targets = append(c.Targets, &target)
 	}
+	c.TargetSets = append(c.TargetSets, t)
 	return nil
 }
 
+// hasScheme reports whether the config has a target of the scheme
+func (c *Config) hasScheme(scheme string) bool {
+	for _, t := range c.Targets {
+		if t.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) Tags() Tags {
 	tags := map[string]int{}
 	for _, t := range c.TargetSets {
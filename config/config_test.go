@@ -43,8 +43,70 @@ func TestFilterTargets(t *testing.T) {
 	}
 }
 
+func TestAddTargetSetStdin(t *testing.T) {
+	var tests = []struct {
+		source   string
+		wantHost string
+		wantPath string
+	}{
+		{"-", "localhost", "-"},
+		{"stdin://", "localhost", "-"},
+		{"stdin://web-1/var/log/app.log", "web-1", "/var/log/app.log"},
+	}
+	for _, tt := range tests {
+		c, err := NewConfig()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		err = c.AddTargetSet(&TargetSet{Sources: []string{tt.source}, Type: "none", Tags: []string{"stdin"}})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got := c.Targets[0]
+		if got.Scheme != "stdin" || got.Host != tt.wantHost || got.Path != tt.wantPath {
+			t.Errorf("%s\ngot %v %v %v\nwant stdin %v %v", tt.source, got.Scheme, got.Host, got.Path, tt.wantHost, tt.wantPath)
+		}
+		if len(c.Tags()) != 1 {
+			t.Errorf("\ngot %v\nwant %v", len(c.Tags()), 1)
+		}
+	}
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = c.AddTargetSet(&TargetSet{Sources: []string{"-", "stdin://"}})
+	if err == nil {
+		t.Error("want error")
+	}
+}
+
 func testdataDir() string {
 	wd, _ := os.Getwd()
 	dir, _ := filepath.Abs(filepath.Join(filepath.Dir(wd), "testdata"))
 	return dir
 }
+
+func TestAddTargetSetSFTPBecome(t *testing.T) {
+	var tests = []struct {
+		become     string
+		becomeUser string
+		wantErr    bool
+	}{
+		{"", "", false},
+		{"none", "", false},
+		{"sudo", "", true},
+		{"doas", "", true},
+		{"", "syslog", true},
+	}
+	for _, tt := range tests {
+		c, err := NewConfig()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		err = c.AddTargetSet(&TargetSet{Sources: []string{"ssh://app-1/var/log/app.log"}, SSHMode: "sftp", Become: tt.become, BecomeUser: tt.becomeUser})
+		if got := err != nil; got != tt.wantErr {
+			t.Errorf("%s %s\ngot %v\nwant %v", tt.become, tt.becomeUser, got, tt.wantErr)
+		}
+	}
+}
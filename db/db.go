@@ -3,8 +3,11 @@ package db
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -17,12 +20,99 @@ import (
 
 // DB ...
 type DB struct {
-	ctx     context.Context
-	db      *sqlx.DB
-	logChan chan parser.Log
-	logger  *zap.Logger
+	ctx        context.Context
+	db         *sqlx.DB
+	logChan    chan parser.Log
+	insertDone chan struct{}
+	dedup      map[int64]map[dedupKey]int
+	lastTs     map[int64]int64
+	insertErrs map[int64]error
+	fields     bool
+	logger     *zap.Logger
+
+	mu            sync.Mutex
+	filePositions map[int64][]FilePosition
+}
+
+// Position is the high-water mark of the logs fetched from the target.
+// Files of ssh and file targets are resumed from their byte offsets ( see FilePosition ), and the overlap is skipped as duplicates.
+type Position struct {
+	TargetId     int64  `db:"target_id"`
+	TsUnixNano   *int64 `db:"ts_unixnano"`
+	FetchedUntil *int64 `db:"fetched_until"`
+}
+
+// FilePosition is the byte offset of the file of the target to resume reading from.
+// The file is identified by the inode and the bytes at the head ( hex ), so that rotated files are resumed by the new path.
+type FilePosition struct {
+	TargetId int64  `db:"target_id"`
+	Path     string `db:"path"`
+	Inode    string `db:"inode"`
+	Head     string `db:"head"`
+	Offset   int64  `db:"byte_offset"`
+}
+
+// FetchResult is the result of the last fetch from the target
+type FetchResult struct {
+	TargetId     int64  `db:"target_id"`
+	Source       string `db:"source"`
+	Lines        int64  `db:"lines"`
+	Bytes        int64  `db:"bytes"`
+	WireBytes    *int64 `db:"wire_bytes"`
+	DecodedBytes *int64 `db:"decoded_bytes"`
+	DurationMs   int64  `db:"duration_ms"`
+	Attempts     int    `db:"attempts"`
+	Status       string `db:"status"`
+	Note         string `db:"note"`
+	Error        string `db:"error"`
+	FetchedAt    string `db:"fetched_at"`
 }
 
+// zeroTsUnixNano is ts_unixnano of logs without timestamp
+var zeroTsUnixNano = (&time.Time{}).UnixNano()
+
+const createPositionsTable = `
+CREATE TABLE IF NOT EXISTS positions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  target_id INTEGER NOT NULL,
+  ts_unixnano INTEGER,
+  fetched_until INTEGER,
+  updated_at TEXT NOT NULL,
+  UNIQUE(target_id)
+);
+`
+
+const createFilePositionsTable = `
+CREATE TABLE IF NOT EXISTS file_positions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  target_id INTEGER NOT NULL,
+  path TEXT NOT NULL,
+  inode TEXT NOT NULL,
+  head TEXT NOT NULL,
+  byte_offset INTEGER NOT NULL,
+  updated_at TEXT NOT NULL,
+  UNIQUE(target_id, inode)
+);
+`
+
+const createFetchResultsTable = `
+CREATE TABLE IF NOT EXISTS fetch_results (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  target_id INTEGER NOT NULL,
+  lines INTEGER NOT NULL,
+  bytes INTEGER NOT NULL,
+  wire_bytes INTEGER,
+  decoded_bytes INTEGER,
+  duration_ms INTEGER NOT NULL,
+  attempts INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  error TEXT NOT NULL,
+  fetched_at TEXT NOT NULL,
+  UNIQUE(target_id)
+);
+`
+
 // NewDB ...
 func NewDB(ctx context.Context, l *zap.Logger, c *config.Config, dbPath string) (*DB, error) {
 	fullPath, err := filepath.Abs(dbPath)
@@ -76,7 +166,8 @@ CREATE VIRTUAL TABLE logs USING FTS4(
   ts_second INTEGER,
   ts_time_zone,
   filled_by_prev_ts INTEGER,
-  content
+  content,
+  fields
 );
 CREATE TABLE metas (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -84,23 +175,129 @@ CREATE TABLE metas (
   value TEXT NOT NULL,
   UNIQUE(key)
 );
-`,
+` + createPositionsTable + createFilePositionsTable + createFetchResultsTable,
 	)
 
-	tags := map[string]int64{}
-	for tag := range c.Tags() {
-		res, err := db.Exec(`INSERT INTO tags (name) VALUES ($1);`, tag)
+	err = registerTargets(db, c, false)
+	if err != nil {
+		return nil, err
+	}
+	l.Info("DB initialized")
+
+	db.MustExec("PRAGMA journal_mode = MEMORY")
+	db.MustExec("PRAGMA synchronous = NORMAL")
+
+	d := newDB(ctx, l, db)
+
+	err = d.SetMeta("harvest.version", version.Version)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = d.SetMeta("db.initialized_at", time.Now().Format(time.RFC3339))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return d, nil
+}
+
+// AppendDB attaches the existing DB to append logs. Targets of the config that are not in the DB are added.
+func AppendDB(ctx context.Context, l *zap.Logger, c *config.Config, dbPath string) (*DB, error) {
+	fullPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	l.Info(fmt.Sprintf("Append to %s", fullPath))
+	db, err := sqlx.Connect("sqlite3", fullPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !hasColumn(db, "logs", "content") {
+		return nil, fmt.Errorf("%s is not a DB of harvest", fullPath)
+	}
+	if !hasColumn(db, "logs", "fields") {
+		// the logs table ( FTS ) can not be altered
+		l.Warn("The DB is created by older harvest, so the fields of logs are not stored")
+	}
+	_, err = db.Exec(createPositionsTable + createFilePositionsTable + createFetchResultsTable)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, col := range []string{"wire_bytes", "decoded_bytes"} {
+		if hasColumn(db, "fetch_results", col) {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE fetch_results ADD COLUMN %s INTEGER;", col))
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		id, err := res.LastInsertId()
+	}
+	if !hasColumn(db, "fetch_results", "note") {
+		_, err = db.Exec("ALTER TABLE fetch_results ADD COLUMN note TEXT NOT NULL DEFAULT '';")
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+	}
+	err = registerTargets(db, c, true)
+	if err != nil {
+		return nil, err
+	}
+
+	db.MustExec("PRAGMA journal_mode = MEMORY")
+	db.MustExec("PRAGMA synchronous = NORMAL")
+
+	return newDB(ctx, l, db), nil
+}
+
+func newDB(ctx context.Context, l *zap.Logger, db *sqlx.DB) *DB {
+	return &DB{
+		ctx:        ctx,
+		db:         db,
+		logger:     l,
+		logChan:    make(chan parser.Log),
+		insertDone: make(chan struct{}),
+		dedup:      map[int64]map[dedupKey]int{},
+		lastTs:     map[int64]int64{},
+		insertErrs: map[int64]error{},
+		fields:     hasColumn(db, "logs", "fields"),
+
+		filePositions: map[int64][]FilePosition{},
+	}
+}
+
+// registerTargets inserts the tags and the targets of the config, and sets the id of the targets.
+// If reuse is true, the id of the same target in the DB is reused.
+func registerTargets(db *sqlx.DB, c *config.Config, reuse bool) error {
+	tags := map[string]int64{}
+	for tag := range c.Tags() {
+		_, err := db.Exec(`INSERT OR IGNORE INTO tags (name) VALUES ($1);`, tag)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		var id int64
+		err = db.Get(&id, `SELECT id FROM tags WHERE name = $1;`, tag)
+		if err != nil {
+			return errors.WithStack(err)
+		}
 		tags[tag] = id
 	}
 
 	for _, t := range c.Targets {
+		if reuse {
+			ids := []int64{}
+			err := db.Select(&ids, `SELECT id FROM targets WHERE source = $1 AND type = $2 AND regexp = $3 AND multi_line = $4 AND time_format = $5 AND time_zone = $6 ORDER BY id LIMIT 1;`, t.Source, t.Type, t.Regexp, t.MultiLine, t.TimeFormat, t.TimeZone)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if len(ids) > 0 {
+				t.Id = ids[0]
+				err := insertTargetTags(db, t, tags)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
 		res, err := db.NamedExec(`
 INSERT INTO targets (
   source,
@@ -130,42 +327,29 @@ INSERT INTO targets (
   :path
 );`, t)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return errors.WithStack(err)
 		}
 		id, err := res.LastInsertId()
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return errors.WithStack(err)
 		}
 		t.Id = id
-		for _, tag := range t.Tags {
-			_, err := db.Exec(`INSERT INTO targets_tags (target_id, tag_id) VALUES ($1, $2);`, id, tags[tag])
-			if err != nil {
-				return nil, errors.WithStack(err)
-			}
+		err = insertTargetTags(db, t, tags)
+		if err != nil {
+			return err
 		}
 	}
-	l.Info("DB initialized")
-
-	db.MustExec("PRAGMA journal_mode = MEMORY")
-	db.MustExec("PRAGMA synchronous = NORMAL")
-
-	d := &DB{
-		ctx:     ctx,
-		db:      db,
-		logger:  l,
-		logChan: make(chan parser.Log),
-	}
+	return nil
+}
 
-	err = d.SetMeta("harvest.version", version.Version)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	err = d.SetMeta("db.initialized_at", time.Now().Format(time.RFC3339))
-	if err != nil {
-		return nil, errors.WithStack(err)
+func insertTargetTags(db *sqlx.DB, t *config.Target, tags map[string]int64) error {
+	for _, tag := range t.Tags {
+		_, err := db.Exec(`INSERT OR IGNORE INTO targets_tags (target_id, tag_id) VALUES ($1, $2);`, t.Id, tags[tag])
+		if err != nil {
+			return errors.WithStack(err)
+		}
 	}
-
-	return d, nil
+	return nil
 }
 
 // AttachDB ...
@@ -182,12 +366,7 @@ func AttachDB(ctx context.Context, l *zap.Logger, dbPath string) (*DB, error) {
 	db.MustExec("PRAGMA journal_mode = MEMORY")
 	db.MustExec("PRAGMA synchronous = NORMAL")
 
-	return &DB{
-		ctx:     ctx,
-		db:      db,
-		logger:  l,
-		logChan: make(chan parser.Log),
-	}, nil
+	return newDB(ctx, l, db), nil
 }
 
 // In ...
@@ -196,9 +375,12 @@ func (d *DB) In() chan parser.Log {
 }
 
 // StartInsert ...
+// Logs of the target are not inserted after the insertion of a log of the target fails ( see InsertErrors ).
 func (d *DB) StartInsert() {
-	defer close(d.logChan)
+	defer close(d.insertDone)
 	count := 0
+	skipped := 0
+	stopped := false
 
 	ticker := time.NewTicker(time.Duration(10) * time.Second)
 	go func() {
@@ -208,14 +390,15 @@ func (d *DB) StartInsert() {
 		}
 	}()
 
-L:
-	for log := range d.logChan {
-		ts := log.Timestamp
-		if ts == nil {
-			ts = &time.Time{}
-		}
-
-		_, err := d.db.Exec(`
+	fieldsCol := ",\n  fields"
+	fieldsVal := ", $15"
+	if !d.fields {
+		// DB created by older harvest
+		fieldsCol = ""
+		fieldsVal = ""
+	}
+	/* #nosec */
+	query := fmt.Sprintf(`
 INSERT INTO logs (
   host,
   path,
@@ -230,8 +413,28 @@ INSERT INTO logs (
   ts_time_zone,
   target_id,
   filled_by_prev_ts,
-  content
-) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);`,
+  content%s
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14%s);`, fieldsCol, fieldsVal)
+
+	for log := range d.logChan {
+		// keep receiving logs after stopping so that the senders are not blocked
+		if stopped {
+			continue
+		}
+		if _, ok := d.insertErrs[log.Target.Id]; ok {
+			continue
+		}
+		ts := log.Timestamp
+		if ts == nil {
+			ts = &time.Time{}
+		}
+		if d.isDuplicate(log, ts) {
+			d.updateLastTs(log.Target.Id, ts)
+			skipped++
+			continue
+		}
+
+		args := []interface{}{
 			log.Host,
 			log.Path,
 			ts,
@@ -246,21 +449,217 @@ INSERT INTO logs (
 			log.Target.Id,
 			log.FilledByPrevTs,
 			log.Content,
-		)
+		}
+		if d.fields {
+			args = append(args, log.Fields)
+		}
+		_, err := d.db.Exec(query, args...)
 		if err != nil {
-			d.logger.Error("DB error", zap.String("error", err.Error()))
-			break L
+			d.logger.Error("DB error", zap.Int64("target_id", log.Target.Id), zap.String("error", err.Error()))
+			d.insertErrs[log.Target.Id] = errors.Wrap(err, "DB error")
+			continue
 		}
+		d.updateLastTs(log.Target.Id, ts)
 		count++
 		select {
 		case <-d.ctx.Done():
-			break L
+			stopped = true
 		default:
 		}
 	}
+	if skipped > 0 {
+		d.logger.Info(fmt.Sprintf("%d duplicate log data are skipped", skipped))
+	}
 	d.logger.Info(fmt.Sprintf("%d log data are fetched", count))
 }
 
+// updateLastTs updates the timestamp of the last log of the target in the DB
+func (d *DB) updateLastTs(targetId int64, ts *time.Time) {
+	if d.lastTs[targetId] < ts.UnixNano() {
+		d.lastTs[targetId] = ts.UnixNano()
+	}
+}
+
+// InsertErrors returns the errors of the insertion of logs of each target.
+// Call after StopInsert.
+func (d *DB) InsertErrors() map[int64]error {
+	return d.insertErrs
+}
+
+// StopInsert closes the channel of logs and waits for the insertion to finish
+func (d *DB) StopInsert() {
+	close(d.logChan)
+	<-d.insertDone
+}
+
+// dedupKey is the hash of the log, so that the contents of logs loaded for deduplication are not kept in memory
+type dedupKey [16]byte
+
+func newDedupKey(host, path, content string, tsUnixNano int64) dedupKey {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(strings.Join([]string{strconv.FormatInt(tsUnixNano, 10), host, path, content}, "\x00")))
+	var k dedupKey
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// isDuplicate reports whether the log is already in the DB ( loaded by LoadDedup )
+func (d *DB) isDuplicate(log parser.Log, ts *time.Time) bool {
+	keys, ok := d.dedup[log.Target.Id]
+	if !ok {
+		return false
+	}
+	key := newDedupKey(log.Host, log.Path, log.Content, ts.UnixNano())
+	if keys[key] == 0 {
+		return false
+	}
+	keys[key]--
+	return true
+}
+
+// LoadDedup loads logs after the start time of each target to skip the same logs fetched again.
+// Logs without timestamp are always loaded.
+func (d *DB) LoadDedup(sts map[int64]*time.Time) error {
+	if len(sts) == 0 {
+		return nil
+	}
+	conds := []string{}
+	args := []interface{}{}
+	for targetId, st := range sts {
+		if st == nil {
+			conds = append(conds, "target_id = ?")
+			args = append(args, targetId)
+			continue
+		}
+		conds = append(conds, "(target_id = ? AND (ts_unixnano > ? OR ts_unixnano = ?))")
+		args = append(args, targetId, st.UnixNano(), zeroTsUnixNano)
+	}
+	/* #nosec */
+	rows, err := d.db.Queryx(fmt.Sprintf(`SELECT target_id, ts_unixnano, host, path, content FROM logs WHERE %s;`, strings.Join(conds, " OR ")), args...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var (
+			targetId   int64
+			tsUnixNano int64
+			host       string
+			path       string
+			content    string
+		)
+		err := rows.Scan(&targetId, &tsUnixNano, &host, &path, &content)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, ok := d.dedup[targetId]; !ok {
+			d.dedup[targetId] = map[dedupKey]int{}
+		}
+		d.dedup[targetId][newDedupKey(host, path, content, tsUnixNano)]++
+		count++
+	}
+	d.logger.Debug(fmt.Sprintf("%d log data are loaded for deduplication", count))
+	return errors.WithStack(rows.Err())
+}
+
+// GetPositions returns the positions of the targets
+func (d *DB) GetPositions() (map[int64]Position, error) {
+	pp := []Position{}
+	err := d.db.Select(&pp, `SELECT target_id, ts_unixnano, fetched_until FROM positions;`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	positions := map[int64]Position{}
+	for _, p := range pp {
+		positions[p.TargetId] = p
+	}
+	return positions, nil
+}
+
+// GetFilePositions returns the offsets of the files of the targets
+func (d *DB) GetFilePositions() (map[int64][]FilePosition, error) {
+	pp := []FilePosition{}
+	err := d.db.Select(&pp, `SELECT target_id, path, inode, head, byte_offset FROM file_positions ORDER BY id;`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	positions := map[int64][]FilePosition{}
+	for _, p := range pp {
+		positions[p.TargetId] = append(positions[p.TargetId], p)
+	}
+	return positions, nil
+}
+
+// SetFilePositions sets the offsets of the files of the target read by the fetch. They are saved by SavePositions.
+func (d *DB) SetFilePositions(targetId int64, positions []FilePosition) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.filePositions[targetId] = positions
+}
+
+// SavePositions saves the timestamp of the last log of each target, and et as the fetched time and the offsets of the files of the targets that are not failed.
+// Call after StopInsert.
+func (d *DB) SavePositions(targets []*config.Target, et *time.Time, failed map[int64]bool) error {
+	now := time.Now().Format(time.RFC3339)
+	for _, t := range targets {
+		var (
+			tsUnixNano   *int64
+			fetchedUntil *int64
+		)
+		if ts, ok := d.lastTs[t.Id]; ok && ts != zeroTsUnixNano {
+			tsUnixNano = &ts
+		}
+		if !failed[t.Id] && et != nil {
+			fu := et.UnixNano()
+			fetchedUntil = &fu
+		}
+		if err := d.saveFilePositions(t.Id, failed[t.Id], now); err != nil {
+			return err
+		}
+		if tsUnixNano == nil && fetchedUntil == nil {
+			continue
+		}
+		_, err := d.db.Exec(`
+INSERT INTO positions (target_id, ts_unixnano, fetched_until, updated_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT(target_id) DO UPDATE SET
+  ts_unixnano = MAX(IFNULL(ts_unixnano, excluded.ts_unixnano), IFNULL(excluded.ts_unixnano, ts_unixnano)),
+  fetched_until = MAX(IFNULL(fetched_until, excluded.fetched_until), IFNULL(excluded.fetched_until, fetched_until)),
+  updated_at = excluded.updated_at;`, t.Id, tsUnixNano, fetchedUntil, now)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// saveFilePositions replaces the offsets of the files of the target with the offsets set by SetFilePositions
+func (d *DB) saveFilePositions(targetId int64, failed bool, now string) error {
+	d.mu.Lock()
+	positions, ok := d.filePositions[targetId]
+	d.mu.Unlock()
+	if !ok || failed {
+		return nil
+	}
+	_, err := d.db.Exec(`DELETE FROM file_positions WHERE target_id = $1;`, targetId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, p := range positions {
+		_, err := d.db.Exec(`
+INSERT INTO file_positions (target_id, path, inode, head, byte_offset, updated_at) VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT(target_id, inode) DO UPDATE SET
+  path = excluded.path,
+  head = excluded.head,
+  byte_offset = excluded.byte_offset,
+  updated_at = excluded.updated_at;`, targetId, p.Path, p.Inode, p.Head, p.Offset, now)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
 // Cat ...
 func (d *DB) Cat(cond string) chan parser.Log {
 	tt, err := d.GetTargetIdAndTags()
@@ -269,6 +668,11 @@ func (d *DB) Cat(cond string) chan parser.Log {
 		close(d.logChan)
 		return d.logChan
 	}
+	fieldsCol := "logs.fields"
+	if !d.hasLogsColumn("fields") {
+		// DB created by older harvest
+		fieldsCol = "''"
+	}
 	go func() {
 		defer close(d.logChan)
 		log := parser.Log{}
@@ -280,6 +684,7 @@ SELECT
   logs.ts_unixnano,
   logs.filled_by_prev_ts,
   logs.content,
+  %s AS fields,
   targets.id AS "target.id",
   targets.source AS "target.source",
 	targets.description AS "target.description",
@@ -295,7 +700,7 @@ SELECT
 	targets.path AS "target.path"
 FROM logs LEFT JOIN targets ON logs.target_id = targets.id
 %s
-ORDER BY logs.ts_unixnano, logs.rowid ASC;`, cond))
+ORDER BY logs.ts_unixnano, logs.rowid ASC;`, fieldsCol, cond))
 		if err != nil {
 			d.logger.Error("DB error", zap.String("error", err.Error()))
 			return
@@ -321,6 +726,36 @@ ORDER BY logs.ts_unixnano, logs.rowid ASC;`, cond))
 	return d.logChan
 }
 
+// hasLogsColumn returns whether the logs table has the column
+func (d *DB) hasLogsColumn(name string) bool {
+	return hasColumn(d.db, "logs", name)
+}
+
+func hasColumn(db *sqlx.DB, table, name string) bool {
+	rows, err := db.Queryx(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return false
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := map[string]interface{}{}
+		if err := rows.MapScan(c); err != nil {
+			return false
+		}
+		switch v := c["name"].(type) {
+		case string:
+			if v == name {
+				return true
+			}
+		case []byte:
+			if string(v) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // resultHost ...
 type resultHost struct {
 	Host string `db:"host"`
@@ -552,13 +987,62 @@ func (d *DB) Count(groups []string, matches []string) ([][]string, error) {
 }
 
 func (d *DB) SetMeta(key string, value string) error {
-	_, err := d.db.Exec(`INSERT INTO metas (key, value) VALUES ($1, $2);`, key, value)
+	_, err := d.db.Exec(`INSERT INTO metas (key, value) VALUES ($1, $2) ON CONFLICT(key) DO UPDATE SET value = excluded.value;`, key, value)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
+// SetFetchResult saves the result of the fetch from the target ( replaces the result of the previous fetch )
+func (d *DB) SetFetchResult(r FetchResult) error {
+	_, err := d.db.NamedExec(`
+INSERT INTO fetch_results (target_id, lines, bytes, wire_bytes, decoded_bytes, duration_ms, attempts, status, note, error, fetched_at)
+VALUES (:target_id, :lines, :bytes, :wire_bytes, :decoded_bytes, :duration_ms, :attempts, :status, :note, :error, :fetched_at)
+ON CONFLICT(target_id) DO UPDATE SET
+  lines = excluded.lines,
+  bytes = excluded.bytes,
+  wire_bytes = excluded.wire_bytes,
+  decoded_bytes = excluded.decoded_bytes,
+  duration_ms = excluded.duration_ms,
+  attempts = excluded.attempts,
+  status = excluded.status,
+  note = excluded.note,
+  error = excluded.error,
+  fetched_at = excluded.fetched_at;`, r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// GetFetchResults returns the results of the last fetch from the targets. DBs created by older versions have no results.
+func (d *DB) GetFetchResults() ([]FetchResult, error) {
+	rr := []FetchResult{}
+	var n int
+	err := d.db.Get(&n, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'fetch_results';`)
+	if err != nil || n == 0 {
+		return rr, errors.WithStack(err)
+	}
+	transferredCols := "r.wire_bytes, r.decoded_bytes"
+	if !hasColumn(d.db, "fetch_results", "wire_bytes") {
+		transferredCols = "NULL AS wire_bytes, NULL AS decoded_bytes"
+	}
+	noteCol := "r.note"
+	if !hasColumn(d.db, "fetch_results", "note") {
+		noteCol = "'' AS note"
+	}
+	err = d.db.Select(&rr, fmt.Sprintf(`
+SELECT r.target_id, t.source, r.lines, r.bytes, %s, r.duration_ms, r.attempts, r.status, %s, r.error, r.fetched_at
+FROM fetch_results AS r
+LEFT JOIN targets AS t ON t.id = r.target_id
+ORDER BY r.target_id;`, transferredCols, noteCol))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rr, nil
+}
+
 type resultMeta struct {
 	Key   string `db:"key"`
 	Value string `db:"value"`
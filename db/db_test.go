@@ -0,0 +1,333 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/k1LoW/harvest/config"
+	"github.com/k1LoW/harvest/parser"
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+var base = time.Date(2019, 10, 15, 8, 0, 0, 0, time.UTC)
+
+func TestAppendDB(t *testing.T) {
+	dir, dbPath := newTestDBPath(t)
+	defer os.RemoveAll(dir)
+
+	c := newTestConfig(t)
+	d, err := NewDB(context.Background(), zap.NewNop(), c, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := c.Targets[0]
+	insertLogs(d, target, []string{"a", "b", "c"}, 0)
+	et := base.Add(10 * time.Second)
+	if err := d.SavePositions(c.Targets, &et, map[int64]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.db.Close()
+
+	// fetch the logs from the second log again
+	c = newTestConfig(t)
+	d, err = AppendDB(context.Background(), zap.NewNop(), c, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Targets[0].Id, target.Id; got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+	st := base.Add(500 * time.Millisecond)
+	if err := d.LoadDedup(map[int64]*time.Time{c.Targets[0].Id: &st}); err != nil {
+		t.Fatal(err)
+	}
+	// logs before the start time are not loaded
+	if got, want := len(d.dedup[c.Targets[0].Id]), 2; got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+	insertLogs(d, c.Targets[0], []string{"b", "c", "d", "e"}, 1)
+	if got := d.InsertErrors(); len(got) != 0 {
+		t.Errorf("\ngot %v\nwant %v", got, map[int64]error{})
+	}
+	et2 := base.Add(20 * time.Second)
+	if err := d.SavePositions(c.Targets, &et2, map[int64]bool{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, d), "[a b c d e]"; got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+	positions, err := d.GetPositions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := positions[c.Targets[0].Id]
+	if p.TsUnixNano == nil || p.FetchedUntil == nil {
+		t.Fatalf("\ngot %v\nwant the timestamp of the last log and the fetched time", p)
+	}
+	if got, want := *p.TsUnixNano, base.Add(4*time.Second).UnixNano(); got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+	if got, want := *p.FetchedUntil, et2.UnixNano(); got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+}
+
+func TestAppendDBWithoutFields(t *testing.T) {
+	dir, dbPath := newTestDBPath(t)
+	defer os.RemoveAll(dir)
+
+	// the schema of the DB created by older harvest
+	db, err := sqlx.Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`
+CREATE TABLE targets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  source TEXT NOT NULL,
+  description TEXT,
+  type TEXT NOT NULL,
+  regexp TEXT,
+  multi_line INTEGER,
+  time_format TEXT,
+  time_zone TEXT,
+  scheme TEXT NOT NULL,
+  host TEXT,
+  user TEXT,
+  port INTEGER,
+  path TEXT NOT NULL
+);
+CREATE TABLE tags (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  UNIQUE(name)
+);
+CREATE TABLE targets_tags (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  target_id INTEGER NOT NULL,
+  tag_id INTEGER NOT NULL,
+  UNIQUE(target_id, tag_id)
+);
+CREATE VIRTUAL TABLE logs USING FTS4(
+  host,
+  path,
+  target_id INTEGER,
+  ts,
+  ts_unixnano INTEGER,
+  ts_year INTEGER,
+  ts_month INTEGER,
+  ts_day INTEGER,
+  ts_hour INTEGER,
+  ts_minute INTEGER,
+  ts_second INTEGER,
+  ts_time_zone,
+  filled_by_prev_ts INTEGER,
+  content
+);
+CREATE TABLE metas (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  UNIQUE(key)
+);`)
+	_ = db.Close()
+
+	c := newTestConfig(t)
+	d, err := AppendDB(context.Background(), zap.NewNop(), c, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertLogs(d, c.Targets[0], []string{"a", "b"}, 0)
+	if got := d.InsertErrors(); len(got) != 0 {
+		t.Errorf("\ngot %v\nwant %v", got, map[int64]error{})
+	}
+	if got, want := contents(t, d), "[a b]"; got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+}
+
+func TestAppendDBNotHarvest(t *testing.T) {
+	dir, dbPath := newTestDBPath(t)
+	defer os.RemoveAll(dir)
+
+	db, err := sqlx.Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	_ = db.Close()
+
+	if _, err := AppendDB(context.Background(), zap.NewNop(), newTestConfig(t), dbPath); err == nil {
+		t.Error("got nil\nwant error")
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	dir, dbPath := newTestDBPath(t)
+	defer os.RemoveAll(dir)
+
+	c := newTestConfig(t)
+	d, err := NewDB(context.Background(), zap.NewNop(), c, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.db.MustExec(`DROP TABLE logs;`)
+	target := c.Targets[0]
+	insertLogs(d, target, []string{"a", "b"}, 0)
+	if _, ok := d.InsertErrors()[target.Id]; !ok {
+		t.Fatalf("\ngot %v\nwant the error of target %d", d.InsertErrors(), target.Id)
+	}
+	et := base.Add(10 * time.Second)
+	if err := d.SavePositions(c.Targets, &et, map[int64]bool{target.Id: true}); err != nil {
+		t.Fatal(err)
+	}
+	positions, err := d.GetPositions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no position is saved for logs that are not stored
+	if p, ok := positions[target.Id]; ok {
+		t.Errorf("\ngot %v\nwant no position", p)
+	}
+}
+
+func TestSavePositions(t *testing.T) {
+	dir, dbPath := newTestDBPath(t)
+	defer os.RemoveAll(dir)
+
+	c := newTestConfig(t)
+	d, err := NewDB(context.Background(), zap.NewNop(), c, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := c.Targets[0]
+	insertLogs(d, target, []string{"a", "b"}, 0)
+	et := base.Add(10 * time.Second)
+	if err := d.SavePositions(c.Targets, &et, map[int64]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	// the failed fetch with older logs does not move the position back
+	d.lastTs[target.Id] = base.Add(-time.Hour).UnixNano()
+	et2 := base.Add(20 * time.Second)
+	if err := d.SavePositions(c.Targets, &et2, map[int64]bool{target.Id: true}); err != nil {
+		t.Fatal(err)
+	}
+	positions, err := d.GetPositions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := positions[target.Id]
+	got := fmt.Sprintf("%v %v", formatNullInt64(p.TsUnixNano), formatNullInt64(p.FetchedUntil))
+	want := fmt.Sprintf("%d %d", base.Add(time.Second).UnixNano(), et.UnixNano())
+	if got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+}
+
+func TestSaveFilePositions(t *testing.T) {
+	dir, dbPath := newTestDBPath(t)
+	defer os.RemoveAll(dir)
+
+	c := newTestConfig(t)
+	d, err := NewDB(context.Background(), zap.NewNop(), c, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := c.Targets[0]
+	et := base.Add(10 * time.Second)
+	d.SetFilePositions(target.Id, []FilePosition{
+		{Path: "/var/log/app.log.1", Inode: "1", Head: "32", Offset: 100},
+		{Path: "/var/log/app.log", Inode: "2", Head: "32", Offset: 10},
+	})
+	if err := d.SavePositions(c.Targets, &et, map[int64]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	// the offsets of files not listed by the fetch are removed
+	d.SetFilePositions(target.Id, []FilePosition{
+		{Path: "/var/log/app.log.1", Inode: "2", Head: "32", Offset: 20},
+	})
+	if err := d.SavePositions(c.Targets, &et, map[int64]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	// the offsets of the failed fetch are not saved
+	d.SetFilePositions(target.Id, []FilePosition{})
+	if err := d.SavePositions(c.Targets, &et, map[int64]bool{target.Id: true}); err != nil {
+		t.Fatal(err)
+	}
+	positions, err := d.GetFilePositions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%v", positions[target.Id])
+	want := fmt.Sprintf("%v", []FilePosition{{TargetId: target.Id, Path: "/var/log/app.log.1", Inode: "2", Head: "32", Offset: 20}})
+	if got != want {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+}
+
+func newTestDBPath(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "harvest-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, filepath.Join(dir, "harvest.db")
+}
+
+func newTestConfig(t *testing.T) *config.Config {
+	c, err := config.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.AddTargetSet(&config.TargetSet{
+		Sources:     []string{"file:///var/log/app.log"},
+		Description: "app",
+		Type:        "none",
+		Tags:        []string{"app"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// insertLogs inserts logs with the timestamps from base + offset seconds
+func insertLogs(d *DB, target *config.Target, contents []string, offset int) {
+	d.logChan = make(chan parser.Log)
+	d.insertDone = make(chan struct{})
+	go d.StartInsert()
+	for i, content := range contents {
+		ts := base.Add(time.Duration(offset+i) * time.Second)
+		d.In() <- parser.Log{
+			Host:      "localhost",
+			Path:      "/var/log/app.log",
+			Timestamp: &ts,
+			Content:   content,
+			Target:    target,
+		}
+	}
+	d.StopInsert()
+}
+
+func contents(t *testing.T, d *DB) string {
+	got := []string{}
+	err := d.db.Select(&got, `SELECT content FROM logs ORDER BY ts_unixnano, rowid;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%v", got)
+}
+
+func formatNullInt64(p *int64) string {
+	if p == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%d", *p)
+}
@@ -2,7 +2,6 @@ module github.com/k1LoW/harvest
 
 require (
 	github.com/Azure/go-autorest/autorest v0.2.0 // indirect
-	github.com/Songmu/prompter v0.2.0
 	github.com/antonmedv/expr v1.1.4
 	github.com/araddon/dateparse v0.0.0-20190622164848-0fb0a474d195
 	github.com/go-sql-driver/mysql v1.4.1 // indirect
@@ -14,14 +13,16 @@ require (
 	github.com/k1LoW/duration v1.1.0
 	github.com/k1LoW/sshc v0.0.0-20190816012013-f54f6b90bfef
 	github.com/kevinburke/ssh_config v0.0.0-20190725054713-01f96b0aa0cd // indirect
+	github.com/klauspost/compress v1.15.15
 	github.com/labstack/gommon v0.2.8
 	github.com/lib/pq v1.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.2 // indirect
 	github.com/mattn/go-sqlite3 v1.10.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pkg/errors v0.8.1
+	github.com/pkg/sftp v1.13.6
 	github.com/spf13/cobra v1.0.1-0.20200719220246-c6fe2d4df810
-	github.com/stretchr/testify v1.4.0 // indirect
+	github.com/ulikunitz/xz v0.5.11
 	go.uber.org/zap v1.10.0
 	golang.org/x/crypto v0.17.0
 	golang.org/x/net v0.17.0 // indirect
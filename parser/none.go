@@ -92,6 +92,7 @@ func (p *NoneParser) parseSingleLine(ctx context.Context, cancel context.CancelF
 				Timestamp:      ts,
 				FilledByPrevTs: filledByPrevTs,
 				Content:        line.Content,
+				Fields:         line.Fields,
 				Target:         p.target,
 			}
 		}
@@ -107,9 +108,10 @@ func (p *NoneParser) parseMultipleLine(ctx context.Context, cancel context.Cance
 	contentStash := []string{}
 
 	var (
-		hostStash string
-		pathStash string
-		prevTs    *time.Time
+		hostStash   string
+		pathStash   string
+		fieldsStash map[string]string
+		prevTs      *time.Time
 	)
 
 	if st == nil {
@@ -124,6 +126,7 @@ func (p *NoneParser) parseMultipleLine(ctx context.Context, cancel context.Cance
 				Timestamp:      prevTs,
 				FilledByPrevTs: false,
 				Content:        strings.Join(contentStash, "\n"),
+				Fields:         fieldsStash,
 				Target:         p.target,
 			}
 			close(logChan)
@@ -167,6 +170,7 @@ func (p *NoneParser) parseMultipleLine(ctx context.Context, cancel context.Cance
 						Timestamp:      prevTs,
 						FilledByPrevTs: false,
 						Content:        strings.Join(contentStash, "\n"),
+						Fields:         fieldsStash,
 						Target:         p.target,
 					}
 					logChan <- Log{
@@ -175,6 +179,7 @@ func (p *NoneParser) parseMultipleLine(ctx context.Context, cancel context.Cance
 						Timestamp:      prevTs,
 						FilledByPrevTs: false,
 						Content:        "Harvest parse error: too many rows",
+						Fields:         fieldsStash,
 						Target:         p.target,
 					}
 					contentStash = nil
@@ -191,12 +196,14 @@ func (p *NoneParser) parseMultipleLine(ctx context.Context, cancel context.Cance
 					Timestamp:      prevTs,
 					FilledByPrevTs: false,
 					Content:        strings.Join(contentStash, "\n"),
+					Fields:         fieldsStash,
 					Target:         p.target,
 				}
 			}
 
 			contentStash = nil
 			contentStash = append(contentStash, line.Content)
+			fieldsStash = line.Fields
 			prevTs = ts
 		}
 	}()
@@ -2,7 +2,10 @@ package parser
 
 import (
 	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -20,14 +23,75 @@ type Log struct {
 	TimestampUnixNano int64          `db:"ts_unixnano"`
 	FilledByPrevTs    bool           `db:"filled_by_prev_ts"`
 	Content           string         `db:"content"`
+	Fields            Fields         `db:"fields"`
 	Target            *config.Target `db:"target"`
 }
 
+// Fields is the structured fields of the log ( e.g. priority of journald )
+type Fields map[string]string
+
+// Value ...
+func (f Fields) Value() (driver.Value, error) {
+	if len(f) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan ...
+func (f *Fields) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("invalid fields: %v", src)
+	}
+	*f = nil
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, f)
+}
+
 // Parser ...
 type Parser interface {
 	Parse(ctx context.Context, cancel context.CancelFunc, lineChan <-chan client.Line, tz string, st *time.Time, et *time.Time) <-chan Log
 }
 
+// NewTimestampFunc returns the function extracting the timestamp of a line by the regexp and the time format of the target.
+// Parsers of syslog and combinedLog set them to the target, so the function should be created after the parser.
+func NewTimestampFunc(t *config.Target) (client.TimestampFunc, error) {
+	if t.Regexp == "" || t.TimeFormat == "" {
+		return nil, fmt.Errorf("regexp and timeFormat are required to seek by timestamp: %s", t.Source)
+	}
+	re, err := regexp.Compile(t.Regexp)
+	if err != nil {
+		return nil, err
+	}
+	return func(content, tz string) *time.Time {
+		if t.TimeZone != "" {
+			tz = t.TimeZone
+		}
+		m := re.FindStringSubmatch(content)
+		if len(m) < 2 {
+			return nil
+		}
+		ts, err := parseTime(t.TimeFormat, tz, m[1])
+		if err != nil {
+			return nil
+		}
+		return ts
+	}, nil
+}
+
 func parseTime(tf string, tz string, content string) (*time.Time, error) {
 	if tf == "unixtime" {
 		ui, _ := strconv.ParseInt(content, 10, 64)
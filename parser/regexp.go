@@ -110,6 +110,7 @@ func (p *RegexpParser) parseSingleLine(ctx context.Context, cancel context.Cance
 				Timestamp:      ts,
 				FilledByPrevTs: filledByPrevTs,
 				Content:        line.Content,
+				Fields:         line.Fields,
 				Target:         p.target,
 			}
 		}
@@ -125,9 +126,10 @@ func (p *RegexpParser) parseMultipleLine(ctx context.Context, cancel context.Can
 	re := regexp.MustCompile(p.target.Regexp)
 	contentStash := []string{}
 	var (
-		prevTs    *time.Time
-		hostStash string
-		pathStash string
+		prevTs      *time.Time
+		hostStash   string
+		pathStash   string
+		fieldsStash map[string]string
 	)
 
 	if st == nil {
@@ -142,6 +144,7 @@ func (p *RegexpParser) parseMultipleLine(ctx context.Context, cancel context.Can
 				Timestamp:      prevTs,
 				FilledByPrevTs: false,
 				Content:        strings.Join(contentStash, "\n"),
+				Fields:         fieldsStash,
 				Target:         p.target,
 			}
 			p.logger.Debug("Close chan parser.Log")
@@ -197,6 +200,7 @@ func (p *RegexpParser) parseMultipleLine(ctx context.Context, cancel context.Can
 						Timestamp:      prevTs,
 						FilledByPrevTs: false,
 						Content:        strings.Join(contentStash, "\n"),
+						Fields:         fieldsStash,
 						Target:         p.target,
 					}
 					logChan <- Log{
@@ -205,6 +209,7 @@ func (p *RegexpParser) parseMultipleLine(ctx context.Context, cancel context.Can
 						Timestamp:      prevTs,
 						FilledByPrevTs: false,
 						Content:        "Harvest parse error: too many rows",
+						Fields:         fieldsStash,
 						Target:         p.target,
 					}
 					contentStash = nil
@@ -221,12 +226,14 @@ func (p *RegexpParser) parseMultipleLine(ctx context.Context, cancel context.Can
 					Timestamp:      prevTs,
 					FilledByPrevTs: false,
 					Content:        strings.Join(contentStash, "\n"),
+					Fields:         fieldsStash,
 					Target:         p.target,
 				}
 			}
 
 			contentStash = nil
 			contentStash = append(contentStash, line.Content)
+			fieldsStash = line.Fields
 			prevTs = ts
 		}
 	}()
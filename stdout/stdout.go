@@ -2,6 +2,7 @@ package stdout
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/k1LoW/harvest/parser"
@@ -46,6 +47,7 @@ type Stdout struct {
 	withHost          bool
 	withPath          bool
 	withTag           bool
+	withFields        bool
 	withoutMark       bool
 	hFmt              string
 	tFmt              string
@@ -58,6 +60,7 @@ func NewStdout(withTimestamp bool,
 	withHost bool,
 	withPath bool,
 	withTag bool,
+	withFields bool,
 	withoutMark bool,
 	hLen int,
 	tLen int,
@@ -69,6 +72,7 @@ func NewStdout(withTimestamp bool,
 		withHost:          withHost,
 		withPath:          withPath,
 		withTag:           withTag,
+		withFields:        withFields,
 		withoutMark:       withoutMark,
 		hFmt:              fmt.Sprintf("%%-%ds ", hLen),
 		tFmt:              fmt.Sprintf("%%-%ds ", tLen),
@@ -91,6 +95,7 @@ func (s *Stdout) Out(logChan chan parser.Log, hosts []string) {
 			filledByPrevTs string
 			host           string
 			tag            string
+			fields         string
 		)
 
 		colorFunc := func(msg interface{}, styles ...string) string {
@@ -130,6 +135,10 @@ func (s *Stdout) Out(logChan chan parser.Log, hosts []string) {
 			tag = fmt.Sprintf(s.tFmt, fmt.Sprintf("%v", log.Target.Tags))
 		}
 
+		if s.withFields && len(log.Fields) > 0 {
+			fields = fmt.Sprintf("%s ", formatFields(log.Fields))
+		}
+
 		if s.withTimestamp || s.withTimestampNano || s.withHost || s.withPath {
 			for i, h := range hosts {
 				if h == log.Host {
@@ -143,8 +152,22 @@ func (s *Stdout) Out(logChan chan parser.Log, hosts []string) {
 			}
 		}
 
-		fmt.Printf("%s%s%s%s%s%s\n", bar, colorFunc(ts), color.White(filledByPrevTs, color.B), colorizeTag(colorFunc, tag), color.Grey(host), log.Content)
+		fmt.Printf("%s%s%s%s%s%s%s\n", bar, colorFunc(ts), color.White(filledByPrevTs, color.B), colorizeTag(colorFunc, tag), color.Grey(host), color.Grey(fields), log.Content)
+	}
+}
+
+// formatFields returns fields as `key=value` in order of key
+func formatFields(f parser.Fields) string {
+	keys := []string{}
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kv := []string{}
+	for _, k := range keys {
+		kv = append(kv, fmt.Sprintf("%s=%s", k, f[k]))
 	}
+	return strings.Join(kv, " ")
 }
 
 func colorizeTag(colorFunc func(interface{}, ...string) string, tag string) string {